@@ -0,0 +1,239 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"realtimemap-temporal/shared"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the desired set of organizations and geofences, geofences are keyed by name.
+type Config struct {
+	Organizations map[string]*Organization
+	Geofences     map[string]*shared.GeofenceDefinition
+}
+
+// DefaultConfig holds the organizations and geofences built into the app.
+func DefaultConfig() *Config {
+	config := &Config{
+		Organizations: make(map[string]*Organization, len(AllOrganizations)),
+		Geofences:     make(map[string]*shared.GeofenceDefinition, len(AllGeofences)),
+	}
+	for id, org := range AllOrganizations {
+		config.Organizations[id] = org
+	}
+	for _, geofence := range AllGeofences {
+		config.Geofences[geofence.Name] = geofence
+	}
+	return config
+}
+
+// OrganizationName is empty for unknown organizations.
+func (c *Config) OrganizationName(id string) string {
+	if org, ok := c.Organizations[id]; ok {
+		return org.Name
+	}
+	return ""
+}
+
+// SortedOrganizations returns the organizations ordered by id.
+func (c *Config) SortedOrganizations() []*Organization {
+	orgs := make([]*Organization, 0, len(c.Organizations))
+	for _, org := range c.Organizations {
+		orgs = append(orgs, org)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Id < orgs[j].Id })
+	return orgs
+}
+
+type configFile struct {
+	Organizations []*organizationConfig        `json:"organizations"`
+	Geofences     []*shared.GeofenceDefinition `json:"geofences"`
+	// GeoJSON is a FeatureCollection with more geofences, relative to the config file.
+	GeoJSON string `json:"geojson"`
+}
+
+type organizationConfig struct {
+	Id        string   `json:"id"`
+	Name      string   `json:"name"`
+	Geofences []string `json:"geofences"`
+}
+
+// LoadConfig reads a YAML (.yaml, .yml), JSON (.json) or GeoJSON (.geojson) file.
+//
+// YAML and JSON files list organizations, each with the names of its geofences, and
+// geofences with GeoJSON geometries, or point to a GeoJSON file with "geojson".
+//
+// GeoJSON files are FeatureCollections of geofences, the "name" property names a feature and
+// "organizations" lists the ids of the organizations it's assigned to. Point features with a
+// "radius" property in meters are circles. Organizations only known from a GeoJSON file are
+// named after the built in ones, or after their id.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &configFile{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// geometries hold raw JSON, so YAML goes through JSON
+		var document any
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		if content, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		fallthrough
+	case ".json":
+		if err := json.Unmarshal(content, file); err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+	case ".geojson":
+		file.GeoJSON = filepath.Base(path)
+	default:
+		return nil, fmt.Errorf("%v: unknown config format, use .yaml, .yml, .json or .geojson", path)
+	}
+
+	if file.GeoJSON != "" {
+		geoJSONPath := file.GeoJSON
+		if !filepath.IsAbs(geoJSONPath) {
+			geoJSONPath = filepath.Join(filepath.Dir(path), geoJSONPath)
+		}
+		if err := file.addGeoJSON(geoJSONPath); err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := file.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return config, nil
+}
+
+type featureCollection struct {
+	Type     string     `json:"type"`
+	Features []*feature `json:"features"`
+}
+
+type feature struct {
+	Geometry   *shared.Geometry `json:"geometry"`
+	Properties struct {
+		Name          string   `json:"name"`
+		Organizations []string `json:"organizations"`
+		Radius        float64  `json:"radius"`
+	} `json:"properties"`
+}
+
+func (file *configFile) addGeoJSON(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	collection := &featureCollection{}
+	if err := json.Unmarshal(content, collection); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	if collection.Type != "FeatureCollection" {
+		return fmt.Errorf("%v: expected a FeatureCollection, got %q", path, collection.Type)
+	}
+
+	orgs := make(map[string]*organizationConfig, len(file.Organizations))
+	for _, org := range file.Organizations {
+		orgs[org.Id] = org
+	}
+
+	for i, feature := range collection.Features {
+		if feature.Geometry == nil {
+			return fmt.Errorf("%v: feature %v has no geometry", path, i)
+		}
+
+		geometry := feature.Geometry
+		if geometry.Type == "Point" {
+			geometry = &shared.Geometry{
+				Type:        shared.GeometryType_CIRCLE,
+				Coordinates: geometry.Coordinates,
+				Radius:      max(geometry.Radius, feature.Properties.Radius),
+			}
+		}
+		file.Geofences = append(file.Geofences, &shared.GeofenceDefinition{
+			Name:     feature.Properties.Name,
+			Geometry: geometry,
+		})
+
+		for _, orgID := range feature.Properties.Organizations {
+			org, ok := orgs[orgID]
+			if !ok {
+				org = &organizationConfig{Id: orgID}
+				if known, ok := AllOrganizations[orgID]; ok {
+					org.Name = known.Name
+				}
+				orgs[orgID] = org
+				file.Organizations = append(file.Organizations, org)
+			}
+			org.Geofences = append(org.Geofences, feature.Properties.Name)
+		}
+	}
+	return nil
+}
+
+// resolve validates the file and links organizations to their geofences.
+func (file *configFile) resolve() (*Config, error) {
+	config := &Config{
+		Organizations: make(map[string]*Organization, len(file.Organizations)),
+		Geofences:     make(map[string]*shared.GeofenceDefinition, len(file.Geofences)),
+	}
+
+	for i, geofence := range file.Geofences {
+		if _, err := geofence.Shape(); err != nil {
+			return nil, fmt.Errorf("geofence %v: %w", i, err)
+		}
+		if _, ok := config.Geofences[geofence.Name]; ok {
+			return nil, fmt.Errorf("geofence %v is defined twice", geofence.Name)
+		}
+		config.Geofences[geofence.Name] = geofence
+	}
+
+	for i, org := range file.Organizations {
+		if org.Id == "" {
+			return nil, fmt.Errorf("organization %v has no id", i)
+		}
+		if _, ok := config.Organizations[org.Id]; ok {
+			return nil, fmt.Errorf("organization %v is defined twice", org.Id)
+		}
+
+		name := org.Name
+		if name == "" {
+			name = org.Id
+		}
+		resolved := &Organization{
+			Id:        org.Id,
+			Name:      name,
+			Geofences: make([]*shared.GeofenceDefinition, 0, len(org.Geofences)),
+		}
+
+		assigned := make(map[string]struct{}, len(org.Geofences))
+		for _, geofenceName := range org.Geofences {
+			geofence, ok := config.Geofences[geofenceName]
+			if !ok {
+				return nil, fmt.Errorf("organization %v: unknown geofence %v", org.Id, geofenceName)
+			}
+			if _, ok := assigned[geofenceName]; ok {
+				continue
+			}
+			assigned[geofenceName] = struct{}{}
+			resolved.Geofences = append(resolved.Geofences, geofence)
+		}
+		config.Organizations[org.Id] = resolved
+	}
+
+	return config, nil
+}
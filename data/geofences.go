@@ -1,54 +1,57 @@
 package data
 
-import (
-	"realtimemap-temporal/shared"
+import "realtimemap-temporal/shared"
 
-	geo "github.com/kellydunn/golang-geo"
-)
-
-var AllGeofences = map[string]*shared.CircularGeofence{
+var AllGeofences = map[string]*shared.GeofenceDefinition{
 	"Airport":           Airport,
 	"Downtown":          Downtown,
 	"RailwaySquare":     RailwaySquare,
 	"LauttasaariIsland": LauttasaariIsland,
 	"LaajasaloIsland":   LaajasaloIsland,
 	"KallioDistrict":    KallioDistrict,
+	"KamppiTerminal":    KamppiTerminal,
 }
 
 var (
-	Airport = &shared.CircularGeofence{
-		Name:            "Airport",
-		CentralPoint:    *geo.NewPoint(60.31146, 24.96907),
-		RadiousInMeters: 2000,
+	Airport = &shared.GeofenceDefinition{
+		Name:     "Airport",
+		Geometry: shared.NewCircleGeometry(60.31146, 24.96907, 2000),
+	}
+
+	Downtown = &shared.GeofenceDefinition{
+		Name:     "Downtown",
+		Geometry: shared.NewCircleGeometry(60.16422983026082, 24.941068845053014, 1700),
 	}
 
-	Downtown = &shared.CircularGeofence{
-		Name:            "Downtown",
-		CentralPoint:    *geo.NewPoint(60.16422983026082, 24.941068845053014),
-		RadiousInMeters: 1700,
+	RailwaySquare = &shared.GeofenceDefinition{
+		Name:     "Railway Square",
+		Geometry: shared.NewCircleGeometry(60.171285, 24.943936, 150),
 	}
 
-	RailwaySquare = &shared.CircularGeofence{
-		Name:            "Railway Square",
-		CentralPoint:    *geo.NewPoint(60.171285, 24.943936),
-		RadiousInMeters: 150,
+	LauttasaariIsland = &shared.GeofenceDefinition{
+		Name:     "Lauttasaari island",
+		Geometry: shared.NewCircleGeometry(60.158536, 24.873788, 1400),
 	}
 
-	LauttasaariIsland = &shared.CircularGeofence{
-		Name:            "Lauttasaari island",
-		CentralPoint:    *geo.NewPoint(60.158536, 24.873788),
-		RadiousInMeters: 1400,
+	LaajasaloIsland = &shared.GeofenceDefinition{
+		Name:     "Laajasalo island",
+		Geometry: shared.NewCircleGeometry(60.16956184470527, 25.052851825093114, 2200),
 	}
 
-	LaajasaloIsland = &shared.CircularGeofence{
-		Name:            "Laajasalo island",
-		CentralPoint:    *geo.NewPoint(60.16956184470527, 25.052851825093114),
-		RadiousInMeters: 2200,
+	KallioDistrict = &shared.GeofenceDefinition{
+		Name:     "Kallio district",
+		Geometry: shared.NewCircleGeometry(60.18260263288996, 24.953588638997264, 600),
 	}
 
-	KallioDistrict = &shared.CircularGeofence{
-		Name:            "Kallio district",
-		CentralPoint:    *geo.NewPoint(60.18260263288996, 24.953588638997264),
-		RadiousInMeters: 600,
+	KamppiTerminal = &shared.GeofenceDefinition{
+		Name: "Kamppi terminal",
+		Geometry: shared.NewPolygonGeometry([][][2]float64{{
+			{24.93032, 60.16826},
+			{24.93412, 60.16851},
+			{24.93398, 60.16987},
+			{24.93110, 60.17004},
+			{24.92996, 60.16925},
+			{24.93032, 60.16826},
+		}}),
 	}
 )
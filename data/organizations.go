@@ -5,7 +5,7 @@ import "realtimemap-temporal/shared"
 type Organization struct {
 	Id        string
 	Name      string
-	Geofences []*shared.CircularGeofence
+	Geofences []*shared.GeofenceDefinition
 }
 
 var AllOrganizations = map[string]*Organization{
@@ -16,17 +16,17 @@ var AllOrganizations = map[string]*Organization{
 	"0012": {
 		Id:        "0012",
 		Name:      "Helsingin Bussiliikenne Oy",
-		Geofences: []*shared.CircularGeofence{Airport, KallioDistrict, RailwaySquare},
+		Geofences: []*shared.GeofenceDefinition{Airport, KallioDistrict, RailwaySquare, KamppiTerminal},
 	},
 	"0017": {
 		Id:        "0017",
 		Name:      "Tammelundin Liikenne Oy",
-		Geofences: []*shared.CircularGeofence{LaajasaloIsland},
+		Geofences: []*shared.GeofenceDefinition{LaajasaloIsland},
 	},
 	"0018": {
 		Id:        "0018",
 		Name:      "Pohjolan Kaupunkiliikenne Oy",
-		Geofences: []*shared.CircularGeofence{KallioDistrict, LauttasaariIsland, RailwaySquare},
+		Geofences: []*shared.GeofenceDefinition{KallioDistrict, LauttasaariIsland, RailwaySquare},
 	},
 	"0020": {
 		Id:   "0020",
@@ -39,12 +39,12 @@ var AllOrganizations = map[string]*Organization{
 	"0022": {
 		Id:        "0022",
 		Name:      "Nobina Finland Oy",
-		Geofences: []*shared.CircularGeofence{Airport, KallioDistrict, LaajasaloIsland},
+		Geofences: []*shared.GeofenceDefinition{Airport, KallioDistrict, LaajasaloIsland},
 	},
 	"0030": {
 		Id:        "0030",
 		Name:      "Savonlinja Oy",
-		Geofences: []*shared.CircularGeofence{Airport, Downtown},
+		Geofences: []*shared.GeofenceDefinition{Airport, Downtown},
 	},
 	"0036": {
 		Id:   "0036",
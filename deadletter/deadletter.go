@@ -0,0 +1,161 @@
+package deadletter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+const (
+	Reason_MALFORMED       = "malformed"
+	Reason_MISSING_FIELDS  = "missing_fields"
+	Reason_INVALID_PAYLOAD = "invalid_payload"
+	Reason_UNKNOWN_EVENT   = "unknown_event"
+)
+
+const (
+	DefaultRecentCapacity = 1000
+	// sinkBufferSize records wait for the sink before new ones are no longer persisted.
+	sinkBufferSize = 1024
+)
+
+// Record is an ingress message that was rejected, with the reason it was rejected for.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason"`
+	Detail    string    `json:"detail"`
+	VehicleId string    `json:"vehicleId,omitempty"`
+	Topic     string    `json:"topic"`
+	Payload   string    `json:"payload"`
+}
+
+// Sink persists dead letters.
+type Sink interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// Reader serves dead letters to the API.
+type Reader interface {
+	// Recent returns up to limit records, newest first, optionally only those with the given reason.
+	Recent(ctx context.Context, reason string, limit int) ([]*Record, error)
+	// Counts returns the number of dead letters per reason.
+	Counts(ctx context.Context) (map[string]int64, error)
+}
+
+// Store forwards dead letters to a sink and keeps the most recent ones in memory for the API.
+// The sink is written from its own goroutine, so a slow sink doesn't hold up ingestion.
+type Store struct {
+	mu      sync.Mutex
+	sink    Sink
+	writes  chan *Record
+	written chan struct{}
+	recent  []*Record
+	next    int
+	counts  map[string]int64
+	dropped int64
+}
+
+// NewStore creates a store keeping capacity recent records, sink may be nil to keep
+// records in memory only.
+func NewStore(sink Sink, capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultRecentCapacity
+	}
+
+	store := &Store{
+		sink:   sink,
+		recent: make([]*Record, 0, capacity),
+		counts: make(map[string]int64),
+	}
+	if sink != nil {
+		store.writes = make(chan *Record, sinkBufferSize)
+		store.written = make(chan struct{})
+		go store.write()
+	}
+	return store
+}
+
+// Add logs the record, keeps it in memory and queues it for the sink. When the sink falls
+// more than sinkBufferSize records behind the record is only kept in memory.
+func (s *Store) Add(record *Record) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now()
+	}
+	slog.Warn("Dead lettered ingress message", "vehicleId", record.VehicleId, "reason", record.Reason, "detail", record.Detail)
+
+	s.mu.Lock()
+	if len(s.recent) < cap(s.recent) {
+		s.recent = append(s.recent, record)
+	} else {
+		s.recent[s.next] = record
+	}
+	s.next = (s.next + 1) % cap(s.recent)
+	s.counts[record.Reason]++
+	s.mu.Unlock()
+
+	if s.sink == nil {
+		return
+	}
+	select {
+	case s.writes <- record:
+	default:
+		s.mu.Lock()
+		s.dropped++
+		dropped := s.dropped
+		s.mu.Unlock()
+		slog.Error("Dead letter sink is falling behind, record kept in memory only", "reason", record.Reason, "dropped", dropped)
+	}
+}
+
+func (s *Store) write() {
+	defer close(s.written)
+	for record := range s.writes {
+		if err := s.sink.Write(record); err != nil {
+			slog.Error("Error writing dead letter", "reason", record.Reason, "error", err)
+		}
+	}
+}
+
+// Recent returns the records kept in memory by this process.
+func (s *Store) Recent(ctx context.Context, reason string, limit int) ([]*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*Record, 0, len(s.recent))
+	for i := 1; i <= len(s.recent); i++ {
+		record := s.recent[(s.next-i+len(s.recent))%len(s.recent)]
+		if reason != "" && record.Reason != reason {
+			continue
+		}
+		records = append(records, record)
+		if limit > 0 && len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+// Counts returns the number of dead letters per reason this process saw since start.
+func (s *Store) Counts(ctx context.Context) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int64, len(s.counts))
+	for reason, count := range s.counts {
+		counts[reason] = count
+	}
+	return counts, nil
+}
+
+// Close writes the queued records to the sink and closes it, Add must not be called afterwards.
+func (s *Store) Close() error {
+	if s.sink == nil {
+		return nil
+	}
+	close(s.writes)
+	<-s.written
+	return s.sink.Close()
+}
@@ -0,0 +1,127 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// memorySink records what it's written, it fails every write while err is set.
+type memorySink struct {
+	mu      sync.Mutex
+	records []*Record
+	err     error
+	closed  bool
+}
+
+func (s *memorySink) Write(record *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func details(records []*Record) []string {
+	result := make([]string, 0, len(records))
+	for _, record := range records {
+		result = append(result, record.Detail)
+	}
+	return result
+}
+
+func TestStoreKeepsMostRecent(t *testing.T) {
+	store := NewStore(nil, 3)
+	for _, record := range []*Record{
+		{Reason: Reason_MALFORMED, Detail: "1"},
+		{Reason: Reason_MISSING_FIELDS, Detail: "2"},
+		{Reason: Reason_MALFORMED, Detail: "3"},
+		{Reason: Reason_MALFORMED, Detail: "4"},
+		{Reason: Reason_UNKNOWN_EVENT, Detail: "5"},
+	} {
+		store.Add(record)
+	}
+
+	tests := []struct {
+		name   string
+		reason string
+		limit  int
+		want   []string
+	}{
+		{"all", "", 0, []string{"5", "4", "3"}},
+		{"limited", "", 2, []string{"5", "4"}},
+		{"by reason", Reason_MALFORMED, 0, []string{"4", "3"}},
+		// 2 was overwritten
+		{"reason no longer kept", Reason_MISSING_FIELDS, 0, []string{}},
+	}
+
+	for _, test := range tests {
+		records, err := store.Recent(context.Background(), test.reason, test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := details(records); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// counts aren't limited to the records kept
+	counts, err := store.Counts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{Reason_MALFORMED: 3, Reason_MISSING_FIELDS: 1, Reason_UNKNOWN_EVENT: 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+}
+
+func TestStoreStampsRecords(t *testing.T) {
+	store := NewStore(nil, 0)
+	record := &Record{Reason: Reason_MALFORMED}
+	store.Add(record)
+
+	if record.Timestamp.IsZero() {
+		t.Error("record added without timestamp")
+	}
+}
+
+func TestStoreWritesSinkBeforeClosing(t *testing.T) {
+	sink := &memorySink{}
+	store := NewStore(sink, 0)
+	for _, detail := range []string{"1", "2", "3"} {
+		store.Add(&Record{Reason: Reason_MALFORMED, Detail: detail})
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := details(sink.records); !reflect.DeepEqual(got, []string{"1", "2", "3"}) {
+		t.Errorf("sink got %v, want 1, 2 and 3 in order", got)
+	}
+	if !sink.closed {
+		t.Error("sink wasn't closed")
+	}
+}
+
+func TestStoreKeepsRecordsTheSinkFailed(t *testing.T) {
+	sink := &memorySink{err: errors.New("disk full")}
+	store := NewStore(sink, 0)
+	store.Add(&Record{Reason: Reason_MALFORMED, Detail: "1"})
+	store.Close()
+
+	records, _ := store.Recent(context.Background(), "", 0)
+	if got := details(records); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("got %v, want the failed record in memory", got)
+	}
+}
@@ -0,0 +1,83 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readNDJSON returns the details of the records in the file at path.
+func readNDJSON(t *testing.T, path string) []string {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	result := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatalf("%v: %v", path, err)
+		}
+		result = append(result, record.Detail)
+	}
+	return result
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.ndjson")
+	line, _ := json.Marshal(&Record{Reason: Reason_MALFORMED, Detail: "0"})
+
+	// room for two records per file, two older generations
+	sink, err := NewFileSink(path, int64(2*(len(line)+1)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, detail := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		if err := sink.Write(&Record{Reason: Reason_MALFORMED, Detail: detail}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	generations := map[string][]string{
+		path:        {"7"},
+		path + ".1": {"5", "6"},
+		path + ".2": {"3", "4"},
+	}
+	for file, want := range generations {
+		if got := readNDJSON(t, file); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v holds %v, want %v", filepath.Base(file), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than two older generations: %v", err)
+	}
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.ndjson")
+
+	// a restarted process appends to the file it left behind
+	for _, detail := range []string{"1", "2"} {
+		sink, err := NewFileSink(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Write(&Record{Reason: Reason_MALFORMED, Detail: detail})
+		sink.Close()
+	}
+
+	if got := readNDJSON(t, path); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Errorf("got %v, want 1 and 2", got)
+	}
+}
@@ -0,0 +1,123 @@
+package deadletter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	DefaultStream       = "ingress_deadletter"
+	DefaultStreamMaxLen = 100000
+)
+
+// RedisSink appends records to a capped Redis stream and counts them per reason in a hash
+// next to it. It's also a Reader, so every instance serves the dead letters of all of them.
+type RedisSink struct {
+	redisCli *redis.Client
+	stream   string
+	counts   string
+	maxLen   int64
+}
+
+func NewRedisSink(redisCli *redis.Client, stream string, maxLen int64) *RedisSink {
+	if stream == "" {
+		stream = DefaultStream
+	}
+	if maxLen <= 0 {
+		maxLen = DefaultStreamMaxLen
+	}
+
+	return &RedisSink{
+		redisCli: redisCli,
+		stream:   stream,
+		counts:   stream + ":counts",
+		maxLen:   maxLen,
+	}
+}
+
+func (s *RedisSink) Write(record *Record) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.redisCli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: s.maxLen,
+			Approx: true,
+			Values: map[string]any{
+				"timestamp": record.Timestamp.Format(time.RFC3339Nano),
+				"reason":    record.Reason,
+				"detail":    record.Detail,
+				"vehicleId": record.VehicleId,
+				"topic":     record.Topic,
+				"payload":   record.Payload,
+			},
+		})
+		pipe.HIncrBy(ctx, s.counts, record.Reason, 1)
+		return nil
+	})
+	return err
+}
+
+// Recent looks at the newest DefaultRecentCapacity records of the stream.
+func (s *RedisSink) Recent(ctx context.Context, reason string, limit int) ([]*Record, error) {
+	messages, err := s.redisCli.XRevRangeN(ctx, s.stream, "+", "-", DefaultRecentCapacity).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, 0, len(messages))
+	for _, message := range messages {
+		record := recordOf(message.Values)
+		if reason != "" && record.Reason != reason {
+			continue
+		}
+		records = append(records, record)
+		if limit > 0 && len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (s *RedisSink) Counts(ctx context.Context) (map[string]int64, error) {
+	values, err := s.redisCli.HGetAll(ctx, s.counts).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(values))
+	for reason, value := range values {
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		counts[reason] = count
+	}
+	return counts, nil
+}
+
+// Close leaves the client open, it's shared with the rest of the application.
+func (s *RedisSink) Close() error {
+	return nil
+}
+
+func recordOf(values map[string]any) *Record {
+	field := func(name string) string {
+		value, _ := values[name].(string)
+		return value
+	}
+
+	timestamp, _ := time.Parse(time.RFC3339Nano, field("timestamp"))
+	return &Record{
+		Timestamp: timestamp,
+		Reason:    field("reason"),
+		Detail:    field("detail"),
+		VehicleId: field("vehicleId"),
+		Topic:     field("topic"),
+		Payload:   field("payload"),
+	}
+}
@@ -0,0 +1,39 @@
+package deadletter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordOfStreamValues(t *testing.T) {
+	timestamp := time.Date(2023, 10, 2, 7, 0, 0, 123456789, time.UTC)
+	record := recordOf(map[string]any{
+		"timestamp": timestamp.Format(time.RFC3339Nano),
+		"reason":    Reason_INVALID_PAYLOAD,
+		"detail":    "lat is not a number",
+		"vehicleId": "0012.1234",
+		"topic":     "/hfp/v2/journey/ongoing/vp/bus/0012/01234",
+		"payload":   `{"VP":{}}`,
+	})
+
+	want := Record{
+		Timestamp: timestamp,
+		Reason:    Reason_INVALID_PAYLOAD,
+		Detail:    "lat is not a number",
+		VehicleId: "0012.1234",
+		Topic:     "/hfp/v2/journey/ongoing/vp/bus/0012/01234",
+		Payload:   `{"VP":{}}`,
+	}
+	if !record.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("got timestamp %v, want %v", record.Timestamp, want.Timestamp)
+	}
+	record.Timestamp = want.Timestamp
+	if *record != want {
+		t.Errorf("got %+v, want %+v", *record, want)
+	}
+
+	// fields missing from an entry are left empty
+	if record := recordOf(map[string]any{"reason": Reason_MALFORMED}); !record.Timestamp.IsZero() || record.Topic != "" {
+		t.Errorf("got %+v from an entry with only a reason", *record)
+	}
+}
@@ -0,0 +1,160 @@
+package ingress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+// CapturedMessage is a raw MQTT message together with the time it was received.
+type CapturedMessage struct {
+	ReceivedAt time.Time `json:"receivedAt"`
+	Topic      string    `json:"topic"`
+	Payload    []byte    `json:"payload"`
+}
+
+// Recorder writes raw MQTT traffic into a gzip compressed NDJSON capture file.
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	encoder *json.Encoder
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	return &Recorder{
+		file:    file,
+		gz:      gz,
+		encoder: json.NewEncoder(gz),
+	}, nil
+}
+
+func (r *Recorder) Record(topic string, payload []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.encoder.Encode(&CapturedMessage{
+		ReceivedAt: time.Now(),
+		Topic:      topic,
+		Payload:    payload,
+	})
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// ReplaySource plays a capture file back while keeping the original inter-message timing.
+// A speed of 1 replays in real time, 10 replays ten times faster and 0 or less replays
+// as fast as possible.
+type ReplaySource struct {
+	feed
+	path   string
+	speed  float64
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewReplaySource(path string, speed float64) *ReplaySource {
+	return &ReplaySource{
+		feed:  newFeed(),
+		path:  path,
+		speed: speed,
+	}
+}
+
+func (s *ReplaySource) Start(ctx context.Context) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		defer file.Close()
+		defer gz.Close()
+
+		decoder := json.NewDecoder(bufio.NewReader(gz))
+
+		var firstReceivedAt, startedAt time.Time
+		count := 0
+		for decoder.More() {
+			msg := &CapturedMessage{}
+			if err := decoder.Decode(msg); err != nil {
+				s.reportError(ctx, fmt.Errorf("%v: %w", s.path, err))
+				return
+			}
+
+			if count == 0 {
+				firstReceivedAt, startedAt = msg.ReceivedAt, time.Now()
+			}
+			count++
+
+			if s.speed > 0 {
+				offset := time.Duration(float64(msg.ReceivedAt.Sub(firstReceivedAt)) / s.speed)
+				if wait := time.Until(startedAt.Add(offset)); wait > 0 {
+					select {
+					case <-time.After(wait):
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			event, err := parseEvent(msg.Topic, msg.Payload)
+			if err != nil {
+				s.reportError(ctx, err)
+				continue
+			}
+
+			select {
+			case s.events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		slog.Info("Finished replaying capture", "path", s.path, "messages", count)
+	}()
+
+	return nil
+}
+
+func (s *ReplaySource) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,97 @@
+package ingress
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"log/slog"
+)
+
+// Message is a raw HFP message as it is published on the broker.
+type Message struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// FileSource reads newline delimited JSON messages from a file, one Message per line.
+type FileSource struct {
+	feed
+	path   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewFileSource(path string) *FileSource {
+	return &FileSource{
+		feed: newFeed(),
+		path: path,
+	}
+}
+
+func (s *FileSource) Start(ctx context.Context) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			msg := &Message{}
+			if err := json.Unmarshal(scanner.Bytes(), msg); err != nil {
+				s.reportError(ctx, &ParseError{
+					Topic:   fmt.Sprintf("%v:%v", s.path, line),
+					Payload: append([]byte(nil), scanner.Bytes()...),
+					Err:     err,
+				})
+				continue
+			}
+
+			event, err := parseEvent(msg.Topic, msg.Payload)
+			if err != nil {
+				s.reportError(ctx, fmt.Errorf("%v:%v: %w", s.path, line, err))
+				continue
+			}
+
+			select {
+			case s.events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			s.reportError(ctx, err)
+		}
+		slog.Info("Finished reading ingress file", "path", s.path, "lines", line)
+	}()
+
+	return nil
+}
+
+func (s *FileSource) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+	<-s.done
+	return nil
+}
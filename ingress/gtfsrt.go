@@ -0,0 +1,470 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"log/slog"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const DefaultGTFSRTPollInterval = 10 * time.Second
+
+type GTFSRTConfig struct {
+	// URL is polled every PollInterval for a FeedMessage.
+	URL          string
+	PollInterval time.Duration
+	// Files is a glob of local FeedMessage files, read once in name order. Used instead of URL.
+	Files string
+	// AgencyId becomes the OrgId of every vehicle unless RouteAgencies maps its route to
+	// another agency, GTFS-RT vehicle positions don't carry the agency themselves.
+	AgencyId      string
+	RouteAgencies map[string]string
+	// TransportMode is reported for every vehicle of the feed, e.g. "bus".
+	TransportMode string
+}
+
+// GTFSRTSource maps the VehiclePosition entities of a GTFS-Realtime feed to vehicle
+// position events, so fleets outside of Helsinki run through the same workflows.
+type GTFSRTSource struct {
+	feed
+	config GTFSRTConfig
+	client *http.Client
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewGTFSRTSource(config GTFSRTConfig) *GTFSRTSource {
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultGTFSRTPollInterval
+	}
+	if config.TransportMode == "" {
+		config.TransportMode = "bus"
+	}
+
+	return &GTFSRTSource{
+		feed:   newFeed(),
+		config: config,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *GTFSRTSource) Start(ctx context.Context) error {
+	if s.config.URL == "" && s.config.Files == "" {
+		return fmt.Errorf("GTFS-RT source needs a URL or files")
+	}
+	if s.config.AgencyId == "" {
+		return fmt.Errorf("GTFS-RT source needs an agency id")
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		if s.config.Files != "" {
+			s.readFiles(ctx)
+			return
+		}
+		s.poll(ctx)
+	}()
+
+	return nil
+}
+
+func (s *GTFSRTSource) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *GTFSRTSource) poll(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if content, err := s.fetch(ctx); err != nil {
+			s.reportError(ctx, err)
+		} else {
+			s.emit(ctx, s.config.URL, content)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *GTFSRTSource) fetch(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", s.config.URL, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *GTFSRTSource) readFiles(ctx context.Context) {
+	paths, err := filepath.Glob(s.config.Files)
+	if err != nil {
+		s.reportError(ctx, err)
+		return
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			s.reportError(ctx, err)
+			continue
+		}
+
+		if !s.emit(ctx, path, content) {
+			return
+		}
+	}
+	slog.Info("Finished reading GTFS-RT files", "files", len(paths))
+}
+
+// emit returns false once ctx is cancelled.
+func (s *GTFSRTSource) emit(ctx context.Context, origin string, content []byte) bool {
+	message, err := decodeFeedMessage(content)
+	if err != nil {
+		s.reportError(ctx, &ParseError{Topic: origin, Err: err})
+		return true
+	}
+
+	for _, vehicle := range message.vehicles {
+		event := s.mapToEvent(message, vehicle)
+		if event == nil {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func (s *GTFSRTSource) mapToEvent(message *feedMessage, vehicle *vehiclePosition) *Event {
+	if !vehicle.hasPosition {
+		return nil
+	}
+
+	vehicleNumber := vehicle.vehicleId
+	if vehicleNumber == "" {
+		vehicleNumber = vehicle.entityId
+	}
+
+	agency := s.config.AgencyId
+	if routeAgency, ok := s.config.RouteAgencies[vehicle.routeId]; ok {
+		agency = routeAgency
+	}
+
+	timestamp := vehicle.timestamp
+	if timestamp == 0 {
+		timestamp = message.timestamp
+	}
+	tst := time.Unix(int64(timestamp), 0).UTC()
+
+	// GTFS-RT has no door state or mandatory bearing and speed, report them as closed and zero
+	latitude, longitude := float64(vehicle.latitude), float64(vehicle.longitude)
+	heading := int32(math.Mod(float64(vehicle.bearing)+360, 360))
+	speed := float64(vehicle.speed)
+	doorState := int32(0)
+	locationSource := "GPS"
+
+	payload := &Payload{
+		Longitude:      &longitude,
+		Latitude:       &latitude,
+		Heading:        &heading,
+		DoorState:      &doorState,
+		Timestamp:      &tst,
+		Speed:          &speed,
+		LocationSource: &locationSource,
+	}
+	if vehicle.routeId != "" {
+		payload.Route = &vehicle.routeId
+	}
+	if vehicle.stopId != "" {
+		stop := StopId(vehicle.stopId)
+		payload.Stop = &stop
+	}
+	if vehicle.hasOdometer {
+		odometer := int64(vehicle.odometer)
+		payload.Odometer = &odometer
+	}
+	if vehicle.hasOccupancy {
+		occupancy := int32(min(vehicle.occupancyPercentage, 100))
+		payload.Occupancy = &occupancy
+	}
+	// GTFS uses HH:MM:SS and YYYYMMDD, HFP uses HH:MM and YYYY-MM-DD
+	if len(vehicle.startTime) >= 5 {
+		start := vehicle.startTime[:5]
+		payload.Start = &start
+	}
+	if day, err := time.Parse("20060102", vehicle.startDate); err == nil {
+		operatingDay := day.Format(time.DateOnly)
+		payload.OperatingDay = &operatingDay
+	}
+
+	topic := &Topic{
+		Prefix:        "gtfsrt",
+		JourneyType:   "journey",
+		TemporalType:  "ongoing",
+		EventType:     "vp",
+		TransportMode: s.config.TransportMode,
+		OperatorId:    agency,
+		VehicleNumber: vehicleNumber,
+		RouteId:       vehicle.routeId,
+	}
+	if payload.Start != nil {
+		topic.StartTime = *payload.Start
+	}
+	// GTFS directions are 0 and 1, HFP directions are 1 and 2
+	if vehicle.hasDirection {
+		topic.DirectionId = strconv.Itoa(int(vehicle.directionId) + 1)
+	}
+
+	return &Event{
+		VehiclePosition: payload,
+		VehicleId:       agency + "." + vehicleNumber,
+		OperatorId:      agency,
+		TransportMode:   s.config.TransportMode,
+		Topic:           topic,
+	}
+}
+
+/*****
+	GTFS-RT DECODING
+*****/
+
+// The feed is decoded straight from the protobuf wire format, only the fields of
+// FeedMessage that are needed for vehicle positions are read.
+
+// Field numbers of the messages in gtfs-realtime.proto,
+// https://github.com/google/transit/blob/master/gtfs-realtime/proto/gtfs-realtime.proto
+const (
+	// FeedMessage
+	fieldFeedMessageHeader protowire.Number = 1 // FeedHeader header
+	fieldFeedMessageEntity protowire.Number = 2 // repeated FeedEntity entity
+
+	// FeedHeader
+	fieldFeedHeaderTimestamp protowire.Number = 3 // uint64 timestamp
+
+	// FeedEntity
+	fieldFeedEntityId        protowire.Number = 1 // string id
+	fieldFeedEntityIsDeleted protowire.Number = 2 // bool is_deleted
+	fieldFeedEntityVehicle   protowire.Number = 4 // VehiclePosition vehicle
+
+	// VehiclePosition
+	fieldVehiclePositionTrip                protowire.Number = 1  // TripDescriptor trip
+	fieldVehiclePositionPosition            protowire.Number = 2  // Position position
+	fieldVehiclePositionTimestamp           protowire.Number = 5  // uint64 timestamp
+	fieldVehiclePositionStopId              protowire.Number = 7  // string stop_id
+	fieldVehiclePositionVehicle             protowire.Number = 8  // VehicleDescriptor vehicle
+	fieldVehiclePositionOccupancyPercentage protowire.Number = 10 // uint32 occupancy_percentage
+
+	// TripDescriptor
+	fieldTripDescriptorStartTime   protowire.Number = 2 // string start_time
+	fieldTripDescriptorStartDate   protowire.Number = 3 // string start_date
+	fieldTripDescriptorRouteId     protowire.Number = 5 // string route_id
+	fieldTripDescriptorDirectionId protowire.Number = 6 // uint32 direction_id
+
+	// Position
+	fieldPositionLatitude  protowire.Number = 1 // float latitude
+	fieldPositionLongitude protowire.Number = 2 // float longitude
+	fieldPositionBearing   protowire.Number = 3 // float bearing
+	fieldPositionOdometer  protowire.Number = 4 // double odometer
+	fieldPositionSpeed     protowire.Number = 5 // float speed
+
+	// VehicleDescriptor
+	fieldVehicleDescriptorId protowire.Number = 1 // string id
+)
+
+type feedMessage struct {
+	timestamp uint64
+	vehicles  []*vehiclePosition
+}
+
+type vehiclePosition struct {
+	entityId            string
+	routeId             string
+	directionId         uint32
+	hasDirection        bool
+	startTime           string
+	startDate           string
+	vehicleId           string
+	latitude            float32
+	longitude           float32
+	bearing             float32
+	speed               float32
+	odometer            float64
+	hasOdometer         bool
+	hasPosition         bool
+	stopId              string
+	timestamp           uint64
+	occupancyPercentage uint32
+	hasOccupancy        bool
+}
+
+func decodeFeedMessage(b []byte) (*feedMessage, error) {
+	message := &feedMessage{}
+
+	err := decodeFields(b, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+		switch num {
+		case fieldFeedMessageHeader:
+			return decodeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+				if num == fieldFeedHeaderTimestamp {
+					message.timestamp = scalar
+				}
+				return nil
+			})
+		case fieldFeedMessageEntity:
+			vehicle := &vehiclePosition{}
+			isVehicle, isDeleted := false, false
+			err := decodeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+				switch num {
+				case fieldFeedEntityId:
+					vehicle.entityId = string(value)
+				case fieldFeedEntityIsDeleted:
+					isDeleted = scalar != 0
+				case fieldFeedEntityVehicle:
+					isVehicle = true
+					return decodeVehiclePosition(value, vehicle)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if isVehicle && !isDeleted {
+				message.vehicles = append(message.vehicles, vehicle)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+func decodeVehiclePosition(b []byte, vehicle *vehiclePosition) error {
+	return decodeFields(b, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+		switch num {
+		case fieldVehiclePositionTrip:
+			return decodeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+				switch num {
+				case fieldTripDescriptorStartTime:
+					vehicle.startTime = string(value)
+				case fieldTripDescriptorStartDate:
+					vehicle.startDate = string(value)
+				case fieldTripDescriptorRouteId:
+					vehicle.routeId = string(value)
+				case fieldTripDescriptorDirectionId:
+					vehicle.directionId, vehicle.hasDirection = uint32(scalar), true
+				}
+				return nil
+			})
+		case fieldVehiclePositionPosition:
+			vehicle.hasPosition = true
+			return decodeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+				switch num {
+				case fieldPositionLatitude:
+					vehicle.latitude = math.Float32frombits(uint32(scalar))
+				case fieldPositionLongitude:
+					vehicle.longitude = math.Float32frombits(uint32(scalar))
+				case fieldPositionBearing:
+					vehicle.bearing = math.Float32frombits(uint32(scalar))
+				case fieldPositionOdometer:
+					vehicle.odometer, vehicle.hasOdometer = math.Float64frombits(scalar), true
+				case fieldPositionSpeed:
+					vehicle.speed = math.Float32frombits(uint32(scalar))
+				}
+				return nil
+			})
+		case fieldVehiclePositionTimestamp:
+			vehicle.timestamp = scalar
+		case fieldVehiclePositionStopId:
+			vehicle.stopId = string(value)
+		case fieldVehiclePositionVehicle:
+			return decodeFields(value, func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error {
+				if num == fieldVehicleDescriptorId {
+					vehicle.vehicleId = string(value)
+				}
+				return nil
+			})
+		case fieldVehiclePositionOccupancyPercentage:
+			vehicle.occupancyPercentage, vehicle.hasOccupancy = uint32(scalar), true
+		}
+		return nil
+	})
+}
+
+// decodeFields walks the fields of a message, handing length delimited values as bytes and
+// every other wire type as a scalar.
+func decodeFields(b []byte, onField func(num protowire.Number, typ protowire.Type, value []byte, scalar uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var value []byte
+		var scalar uint64
+		switch typ {
+		case protowire.VarintType:
+			scalar, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			scalar = uint64(v)
+		case protowire.Fixed64Type:
+			scalar, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := onField(num, typ, value, scalar); err != nil {
+			return err
+		}
+	}
+	return nil
+}
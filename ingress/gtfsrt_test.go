@@ -0,0 +1,117 @@
+package ingress
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// testdata/vehicle_positions.pb is a FeedMessage with five entities: a vehicle with every field
+// read, one without a position, a deleted one, an alert, and one with only a position and a trip.
+func readFeedFixture(t *testing.T) *feedMessage {
+	t.Helper()
+
+	content, err := os.ReadFile("testdata/vehicle_positions.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := decodeFeedMessage(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestDecodeFeedMessage(t *testing.T) {
+	message := readFeedFixture(t)
+
+	if message.timestamp != 1700000000 {
+		t.Errorf("got header timestamp %v, want 1700000000", message.timestamp)
+	}
+	// the deleted vehicle and the alert are skipped
+	if len(message.vehicles) != 3 {
+		t.Fatalf("got %v vehicles, want 3", len(message.vehicles))
+	}
+
+	want := vehiclePosition{
+		entityId: "e1", routeId: "1055", directionId: 0, hasDirection: true,
+		startTime: "08:15:00", startDate: "20231114", vehicleId: "1234",
+		latitude: 60.1699, longitude: 24.9384, bearing: -90, speed: 7.5,
+		odometer: 1234.5, hasOdometer: true, hasPosition: true,
+		stopId: "1130446", timestamp: 1700000005, occupancyPercentage: 130, hasOccupancy: true,
+	}
+	if *message.vehicles[0] != want {
+		t.Errorf("got %+v, want %+v", *message.vehicles[0], want)
+	}
+
+	if vehicle := message.vehicles[1]; vehicle.entityId != "e2" || vehicle.hasPosition || vehicle.routeId != "1055" {
+		t.Errorf("got %+v, want e2 on route 1055 without position", *vehicle)
+	}
+
+	want = vehiclePosition{
+		entityId: "e5", routeId: "2", directionId: 1, hasDirection: true,
+		latitude: 60.1710, longitude: 24.9410, hasPosition: true,
+	}
+	if *message.vehicles[2] != want {
+		t.Errorf("got %+v, want %+v", *message.vehicles[2], want)
+	}
+}
+
+func TestDecodeFeedMessageRejectsTruncated(t *testing.T) {
+	content, err := os.ReadFile("testdata/vehicle_positions.pb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeFeedMessage(content[:len(content)-20]); err == nil {
+		t.Error("decoded a truncated feed")
+	}
+}
+
+func TestGTFSRTSourceMapsVehicles(t *testing.T) {
+	message := readFeedFixture(t)
+	source := NewGTFSRTSource(GTFSRTConfig{
+		AgencyId:      "HSL",
+		RouteAgencies: map[string]string{"2": "0040"},
+	})
+
+	if event := source.mapToEvent(message, message.vehicles[1]); event != nil {
+		t.Errorf("mapped a vehicle without position: %+v", event)
+	}
+
+	event := source.mapToEvent(message, message.vehicles[0])
+	if event == nil {
+		t.Fatal("vehicle e1 wasn't mapped")
+	}
+	if event.VehicleId != "HSL.1234" || event.OperatorId != "HSL" || event.TransportMode != "bus" {
+		t.Errorf("got vehicle %v of %v (%v), want HSL.1234 of HSL (bus)", event.VehicleId, event.OperatorId, event.TransportMode)
+	}
+	if topic := event.Topic; topic.RouteId != "1055" || topic.DirectionId != "1" || topic.StartTime != "08:15" {
+		t.Errorf("got topic %+v, want route 1055 in direction 1 starting 08:15", *topic)
+	}
+	payload := event.VehiclePosition
+	if !payload.Timestamp.Equal(time.Unix(1700000005, 0)) {
+		t.Errorf("got timestamp %v, want the one of the vehicle", payload.Timestamp)
+	}
+	if *payload.Heading != 270 || *payload.Occupancy != 100 || *payload.Odometer != 1234 {
+		t.Errorf("got heading %v, occupancy %v and odometer %v, want 270, 100 and 1234", *payload.Heading, *payload.Occupancy, *payload.Odometer)
+	}
+	if *payload.OperatingDay != "2023-11-14" || string(*payload.Stop) != "1130446" || *payload.Route != "1055" {
+		t.Errorf("got operating day %v, stop %v and route %v", *payload.OperatingDay, *payload.Stop, *payload.Route)
+	}
+
+	// without vehicle descriptor the entity id is the vehicle, without timestamp the header's is used
+	event = source.mapToEvent(message, message.vehicles[2])
+	if event == nil {
+		t.Fatal("vehicle e5 wasn't mapped")
+	}
+	if event.VehicleId != "0040.e5" || event.Topic.DirectionId != "2" {
+		t.Errorf("got vehicle %v in direction %v, want 0040.e5 in direction 2", event.VehicleId, event.Topic.DirectionId)
+	}
+	if !event.VehiclePosition.Timestamp.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("got timestamp %v, want the one of the header", event.VehiclePosition.Timestamp)
+	}
+	if event.VehiclePosition.Odometer != nil || event.VehiclePosition.Occupancy != nil {
+		t.Error("reported an odometer or occupancy the feed doesn't have")
+	}
+}
@@ -0,0 +1,78 @@
+package ingress
+
+import (
+	"sync"
+	"time"
+)
+
+type ConnectionState string
+
+const (
+	ConnectionState_CONNECTING   ConnectionState = "CONNECTING"
+	ConnectionState_CONNECTED    ConnectionState = "CONNECTED"
+	ConnectionState_RECONNECTING ConnectionState = "RECONNECTING"
+	ConnectionState_DOWN         ConnectionState = "DOWN"
+	// ConnectionState_UNKNOWN is reported by instances that don't ingest, such as -role http.
+	ConnectionState_UNKNOWN ConnectionState = "UNKNOWN"
+)
+
+type Health struct {
+	State         ConnectionState `json:"state"`
+	ConnectedAt   *time.Time      `json:"connectedAt,omitempty"`
+	LastMessageAt *time.Time      `json:"lastMessageAt,omitempty"`
+	Reconnects    int             `json:"reconnects"`
+	LastError     string          `json:"lastError,omitempty"`
+}
+
+// HealthReporter is implemented by sources that keep a connection to a broker.
+type HealthReporter interface {
+	Health() Health
+}
+
+// UnknownHealth is the HealthReporter of instances without a source, their API can't tell
+// whether the ingest instances are connected.
+var UnknownHealth HealthReporter = unknownHealth{}
+
+type unknownHealth struct{}
+
+func (unknownHealth) Health() Health {
+	return Health{State: ConnectionState_UNKNOWN}
+}
+
+// healthState is the mutable, concurrency safe counterpart of Health.
+type healthState struct {
+	mu     sync.Mutex
+	health Health
+}
+
+func (h *healthState) Health() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.health
+}
+
+func (h *healthState) setState(state ConnectionState, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state == ConnectionState_CONNECTED {
+		now := time.Now()
+		h.health.ConnectedAt = &now
+	}
+	if state == ConnectionState_RECONNECTING && h.health.State != ConnectionState_RECONNECTING {
+		h.health.Reconnects++
+	}
+	if err != nil {
+		h.health.LastError = err.Error()
+	}
+	h.health.State = state
+}
+
+func (h *healthState) messageReceived() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.health.LastMessageAt = &now
+}
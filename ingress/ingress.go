@@ -3,26 +3,36 @@ package ingress
 import (
 	"context"
 	"encoding/json"
-	"strings"
+	"fmt"
 	"time"
 
 	"log/slog"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"realtimemap-temporal/shared"
 )
 
-const (
-	brokerURL = "ssl://mqtt.hsl.fi:8883"
-	clientID  = "realtimemap-temporal"
-)
+var locationSources = map[string]struct{}{"GPS": {}, "ODO": {}, "MAN": {}, "DR": {}, "N/A": {}}
 
 type Payload struct {
-	Longitude *float64   `json:"long"`
-	Latitude  *float64   `json:"lat"`
-	Heading   *int32     `json:"hdg"`
-	DoorState *int32     `json:"drst"`
-	Timestamp *time.Time `json:"tst"`
-	Speed     *float64   `json:"spd"`
+	Longitude      *float64   `json:"long"`
+	Latitude       *float64   `json:"lat"`
+	Heading        *int32     `json:"hdg"`
+	DoorState      *int32     `json:"drst"`
+	Timestamp      *time.Time `json:"tst"`
+	Speed          *float64   `json:"spd"`
+	Delay          *int32     `json:"dl"`
+	Occupancy      *int32     `json:"occu"`
+	Odometer       *int64     `json:"odo"`
+	Designation    *string    `json:"desi"`
+	Stop           *StopId    `json:"stop"`
+	Route          *string    `json:"route"`
+	OperatingDay   *string    `json:"oday"`
+	Start          *string    `json:"start"`
+	LocationSource *string    `json:"loc"`
+	Acceleration   *float64   `json:"acc"`
+	// AccuracyMeters is the radius of the fix' horizontal accuracy. HFP doesn't report one,
+	// sources whose feed does fill it in.
+	AccuracyMeters *float64 `json:"-"`
 }
 
 func (p *Payload) HasValidPosition() bool {
@@ -32,64 +42,182 @@ func (p *Payload) HasValidPosition() bool {
 		p.Speed != nil && p.DoorState != nil
 }
 
-type Event struct {
-	VehiclePosition *Payload `json:"VP"`
-	DoorOpen        *Payload `json:"DOO"`
-	DoorClosed      *Payload `json:"DOC"`
-	VehicleId       string
-	OperatorId      string
+// Validate checks the optional fields that are present, missing fields are not an error.
+func (p *Payload) Validate() error {
+	if p.DoorState != nil && *p.DoorState != 0 && *p.DoorState != 1 {
+		return fmt.Errorf("invalid door state %v", *p.DoorState)
+	}
+	if p.Occupancy != nil && (*p.Occupancy < 0 || *p.Occupancy > 100) {
+		return fmt.Errorf("invalid occupancy %v", *p.Occupancy)
+	}
+	if p.Odometer != nil && *p.Odometer < 0 {
+		return fmt.Errorf("invalid odometer %v", *p.Odometer)
+	}
+	if p.OperatingDay != nil {
+		if _, err := time.Parse(time.DateOnly, *p.OperatingDay); err != nil {
+			return fmt.Errorf("invalid operating day %q", *p.OperatingDay)
+		}
+	}
+	if p.Start != nil {
+		if _, err := time.Parse("15:04", *p.Start); err != nil {
+			return fmt.Errorf("invalid start time %q", *p.Start)
+		}
+	}
+	if p.LocationSource != nil {
+		if _, ok := locationSources[*p.LocationSource]; !ok {
+			return fmt.Errorf("unknown location source %q", *p.LocationSource)
+		}
+	}
+	return nil
 }
 
-func ConsumeVehicleEvents(onEvent func(*Event), ctx context.Context) <-chan bool {
-	done := make(chan bool)
-	go func() {
-		var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-			event := &Event{}
-
-			//0/1       /2        /3             /4              /5           /6               /7            /8               /9         /10            /11        /12          /13         /14             /15       /16
-			// /<prefix>/<version>/<journey_type>/<temporal_type>/<event_type>/<transport_mode>/<operator_id>/<vehicle_number>/<route_id>/<direction_id>/<headsign>/<start_time>/<next_stop>/<geohash_level>/<geohash>/<sid>/#
-			topicParts := strings.Split(msg.Topic(), "/")
-
-			if err := json.Unmarshal(msg.Payload(), event); err != nil {
-				slog.Error("Error unmarshalling json", err)
-			} else {
-				event.OperatorId = topicParts[7]
-				event.VehicleId = topicParts[7] + "." + topicParts[8]
-				onEvent(event)
-			}
-		}
+// StopId accepts both the numeric and the string form HFP uses for stop ids.
+type StopId string
+
+func (s *StopId) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = StopId(str)
+		return nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("invalid stop id %s", data)
+	}
+	*s = StopId(number.String())
+	return nil
+}
 
-		opts := mqtt.NewClientOptions().
-			AddBroker(brokerURL).
-			SetClientID(clientID).
-			SetDefaultPublishHandler(f).
-			SetKeepAlive(2 * time.Second).
-			SetPingTimeout(1 * time.Second).
-			SetCleanSession(true)
-
-		client := mqtt.NewClient(opts)
-		if token := client.Connect(); token.Wait() && token.Error() != nil {
-			panic(token.Error())
-		}
-		slog.Info("CONNECTED")
+type Event struct {
+	VehiclePosition    *Payload `json:"VP"`
+	DoorOpen           *Payload `json:"DOO"`
+	DoorClosed         *Payload `json:"DOC"`
+	Arrival            *Payload `json:"ARR"`
+	Departure          *Payload `json:"DEP"`
+	ArrivedAtStop      *Payload `json:"ARS"`
+	PredictedDeparture *Payload `json:"PDE"`
+	PassedStop         *Payload `json:"PAS"`
+	Waiting            *Payload `json:"WAIT"`
+	Due                *Payload `json:"DUE"`
+	SignalRequest      *Payload `json:"TLR"`
+	SignalResponse     *Payload `json:"TLA"`
+	JourneyAssigned    *Payload `json:"VJA"`
+	JourneyUnassigned  *Payload `json:"VJOUT"`
+	DriverSignIn       *Payload `json:"DA"`
+	DriverSignOut      *Payload `json:"DOUT"`
+	BlockSignIn        *Payload `json:"BA"`
+	BlockSignOut       *Payload `json:"BOUT"`
+	VehicleId          string
+	OperatorId         string
+	TransportMode      string
+	Topic              *Topic `json:"-"`
+	// RawTopic and RawPayload keep the message the event was parsed from, they're empty
+	// for sources that don't speak HFP.
+	RawTopic   string `json:"-"`
+	RawPayload []byte `json:"-"`
+}
 
-		if token := client.Subscribe("/hfp/v2/journey/ongoing/vp/bus/#", 0, nil); token.Wait() && token.Error() != nil {
-			panic(token.Error())
-		}
-		slog.Info("SUBSCRIBED")
+// ParseError is reported by sources for messages that couldn't be turned into an Event.
+type ParseError struct {
+	Topic   string
+	Payload []byte
+	Err     error
+}
 
-		<-ctx.Done()
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Topic, e.Err)
+}
 
-		if token := client.Unsubscribe("/hfp/v2/journey/ongoing/vp/bus/#"); token.Wait() && token.Error() != nil {
-			panic(token.Error())
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Kind returns the HFP event type of the event together with its payload,
+// an empty kind means the message carried no known event.
+func (e *Event) Kind() (string, *Payload) {
+	kinds := []struct {
+		kind    string
+		payload *Payload
+	}{
+		{shared.VehicleEvent_VP, e.VehiclePosition},
+		{shared.VehicleEvent_DOO, e.DoorOpen},
+		{shared.VehicleEvent_DOC, e.DoorClosed},
+		{shared.VehicleEvent_ARR, e.Arrival},
+		{shared.VehicleEvent_DEP, e.Departure},
+		{shared.VehicleEvent_ARS, e.ArrivedAtStop},
+		{shared.VehicleEvent_PDE, e.PredictedDeparture},
+		{shared.VehicleEvent_PAS, e.PassedStop},
+		{shared.VehicleEvent_WAIT, e.Waiting},
+		{shared.VehicleEvent_DUE, e.Due},
+		{shared.VehicleEvent_TLR, e.SignalRequest},
+		{shared.VehicleEvent_TLA, e.SignalResponse},
+		{shared.VehicleEvent_VJA, e.JourneyAssigned},
+		{shared.VehicleEvent_VJOUT, e.JourneyUnassigned},
+		{shared.VehicleEvent_DA, e.DriverSignIn},
+		{shared.VehicleEvent_DOUT, e.DriverSignOut},
+		{shared.VehicleEvent_BA, e.BlockSignIn},
+		{shared.VehicleEvent_BOUT, e.BlockSignOut},
+	}
+
+	for _, k := range kinds {
+		if k.payload != nil {
+			return k.kind, k.payload
 		}
-		slog.Info("UNSUBSCRIBED")
+	}
+	return "", nil
+}
 
-		client.Disconnect(250)
-		slog.Info("DISCONNECTED")
+// ConsumeVehicleEvents starts the source and hands every event it produces to onEvent and
+// every error to onError, which logs it, until ctx is cancelled, after which the source is
+// stopped and done is signalled. It returns the error of a source that fails to start.
+func ConsumeVehicleEvents(source Source, onEvent func(*Event), onError func(error), ctx context.Context) (<-chan bool, error) {
+	if err := source.Start(ctx); err != nil {
+		return nil, err
+	}
 
-		done <- true
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case event := <-source.Events():
+				onEvent(event)
+			case err := <-source.Errors():
+				onError(err)
+			case <-ctx.Done():
+				if err := source.Stop(); err != nil {
+					slog.Error("Error stopping ingress source", "error", err)
+				}
+				done <- true
+				return
+			}
+		}
 	}()
 
-	return done
+	return done, nil
+}
+
+// parseEvent turns a raw HFP topic and payload pair into an Event.
+func parseEvent(topic string, payload []byte) (*Event, error) {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return nil, &ParseError{Topic: topic, Payload: payload, Err: err}
+	}
+
+	event := &Event{}
+	if err := json.Unmarshal(payload, event); err != nil {
+		return nil, &ParseError{Topic: topic, Payload: payload, Err: fmt.Errorf("error unmarshalling json: %w", err)}
+	}
+
+	event.RawTopic = topic
+	event.RawPayload = payload
+	event.Topic = t
+	event.TransportMode = t.TransportMode
+	event.OperatorId = t.OperatorId
+	event.VehicleId = t.VehicleId()
+	return event, nil
 }
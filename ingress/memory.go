@@ -0,0 +1,39 @@
+package ingress
+
+import "context"
+
+// MemorySource emits events that are pushed into it by the application, it's mainly
+// useful for tests and for embedding the pipeline into other programs.
+type MemorySource struct {
+	feed
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{
+		feed: newFeed(),
+	}
+}
+
+func (s *MemorySource) Start(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemorySource) Stop() error {
+	return nil
+}
+
+// Publish hands an already parsed event to the consumer, blocking while the buffer is full.
+func (s *MemorySource) Publish(event *Event) {
+	s.events <- event
+}
+
+// PublishMessage parses a raw HFP message the same way the MQTT source does.
+func (s *MemorySource) PublishMessage(topic string, payload []byte) error {
+	event, err := parseEvent(topic, payload)
+	if err != nil {
+		return err
+	}
+
+	s.Publish(event)
+	return nil
+}
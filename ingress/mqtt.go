@@ -0,0 +1,216 @@
+package ingress
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"time"
+
+	"log/slog"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	DefaultBrokerURL            = "ssl://mqtt.hsl.fi:8883"
+	DefaultClientID             = "realtimemap-temporal"
+	DefaultConnectRetryInterval = 1 * time.Second
+	DefaultMaxReconnectInterval = 1 * time.Minute
+)
+
+type MQTTConfig struct {
+	BrokerURL string
+	// ClientID must be unique per broker connection, an id derived from DefaultClientID,
+	// the host name and a random suffix is generated when it's empty.
+	ClientID string
+	Filters  TopicFilters
+	// ShareGroup, when set, subscribes through "$share/<group>/" so the broker spreads the
+	// messages over every instance in the group instead of sending each one to all of them.
+	ShareGroup string
+	// Recorder, when set, captures every raw message before it's parsed.
+	Recorder *Recorder
+	// ConnectRetryInterval is the delay between attempts of the initial connection.
+	ConnectRetryInterval time.Duration
+	// MaxReconnectInterval caps the exponential backoff used after the connection is lost.
+	MaxReconnectInterval time.Duration
+}
+
+// MQTTSource consumes HFP messages from an MQTT broker. It keeps reconnecting with backoff
+// and restores all subscriptions every time the connection comes back.
+type MQTTSource struct {
+	feed
+	healthState
+	config MQTTConfig
+	client mqtt.Client
+	cancel context.CancelFunc
+}
+
+func NewMQTTSource(config MQTTConfig) *MQTTSource {
+	if config.BrokerURL == "" {
+		config.BrokerURL = DefaultBrokerURL
+	}
+	if config.ClientID == "" {
+		config.ClientID = UniqueClientID(DefaultClientID)
+	}
+	if len(config.Filters) == 0 {
+		config.Filters = DefaultTopicFilters
+	}
+	if config.ConnectRetryInterval <= 0 {
+		config.ConnectRetryInterval = DefaultConnectRetryInterval
+	}
+	if config.MaxReconnectInterval <= 0 {
+		config.MaxReconnectInterval = DefaultMaxReconnectInterval
+	}
+
+	source := &MQTTSource{
+		feed:   newFeed(),
+		config: config,
+	}
+	source.setState(ConnectionState_DOWN, nil)
+	return source
+}
+
+func (s *MQTTSource) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	// the handler runs on paho's router goroutine, blocking it for good would stall keepalive
+	var f mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
+		s.messageReceived()
+
+		if s.config.Recorder != nil {
+			if err := s.config.Recorder.Record(msg.Topic(), msg.Payload()); err != nil {
+				s.reportError(ctx, err)
+			}
+		}
+
+		event, err := parseEvent(msg.Topic(), msg.Payload())
+		if err != nil {
+			s.reportError(ctx, err)
+			return
+		}
+		select {
+		case s.events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(s.config.BrokerURL).
+		SetClientID(s.config.ClientID).
+		SetDefaultPublishHandler(f).
+		SetKeepAlive(2 * time.Second).
+		SetPingTimeout(1 * time.Second).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(s.config.ConnectRetryInterval).
+		SetMaxReconnectInterval(s.config.MaxReconnectInterval).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			s.onConnect(ctx, client)
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			slog.Warn("CONNECTION LOST", "error", err)
+			s.setState(ConnectionState_RECONNECTING, err)
+		}).
+		SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+			slog.Info("RECONNECTING")
+			s.setState(ConnectionState_RECONNECTING, nil)
+		})
+
+	s.setState(ConnectionState_CONNECTING, nil)
+	s.client = mqtt.NewClient(opts)
+
+	// with ConnectRetry the token only completes once connected, so don't hold up the caller
+	token := s.client.Connect()
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			slog.Error("Error connecting to MQTT broker", "error", token.Error())
+			s.setState(ConnectionState_DOWN, token.Error())
+		}
+	}()
+
+	return nil
+}
+
+// onConnect runs after the initial connection and after every reconnect. The session is
+// clean, so the broker forgot our subscriptions and they have to be made again.
+func (s *MQTTSource) onConnect(ctx context.Context, client mqtt.Client) {
+	slog.Info("CONNECTED")
+
+	subscriptions := s.subscriptions()
+	backoff := s.config.ConnectRetryInterval
+	for client.IsConnectionOpen() {
+		token := client.SubscribeMultiple(subscriptions, nil)
+		if token.Wait() && token.Error() == nil {
+			slog.Info("SUBSCRIBED", "filters", s.config.Filters.String())
+			s.setState(ConnectionState_CONNECTED, nil)
+			return
+		}
+
+		slog.Error("Error subscribing", "error", token.Error(), "retryIn", backoff)
+		s.reportError(ctx, token.Error())
+		s.setState(ConnectionState_RECONNECTING, token.Error())
+
+		time.Sleep(backoff)
+		backoff = min(2*backoff, s.config.MaxReconnectInterval)
+	}
+}
+
+func (s *MQTTSource) subscriptions() map[string]byte {
+	subscriptions := s.config.Filters.Subscriptions()
+	if s.config.ShareGroup == "" {
+		return subscriptions
+	}
+
+	shared := make(map[string]byte, len(subscriptions))
+	for topic, qos := range subscriptions {
+		shared[fmt.Sprintf("$share/%v/%v", s.config.ShareGroup, topic)] = qos
+	}
+	return shared
+}
+
+// UniqueClientID appends the host name and a random suffix to prefix, two instances
+// connecting with the same client id would keep kicking each other off the broker.
+func UniqueClientID(prefix string) string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Sprintf("%v-%v-%v", prefix, hostname, os.Getpid())
+	}
+	return fmt.Sprintf("%v-%v-%x", prefix, hostname, suffix)
+}
+
+func (s *MQTTSource) Stop() error {
+	if s.client == nil {
+		return nil
+	}
+	s.cancel()
+
+	if s.client.IsConnectionOpen() {
+		topics := make([]string, 0, len(s.config.Filters))
+		for topic := range s.subscriptions() {
+			topics = append(topics, topic)
+		}
+
+		if token := s.client.Unsubscribe(topics...); token.Wait() && token.Error() != nil {
+			slog.Error("Error unsubscribing", "error", token.Error())
+		} else {
+			slog.Info("UNSUBSCRIBED")
+		}
+	}
+
+	s.client.Disconnect(250)
+	s.setState(ConnectionState_DOWN, nil)
+	slog.Info("DISCONNECTED")
+
+	if s.config.Recorder != nil {
+		return s.config.Recorder.Close()
+	}
+
+	return nil
+}
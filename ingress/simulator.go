@@ -0,0 +1,407 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"realtimemap-temporal/data"
+	"realtimemap-temporal/shared"
+	"sort"
+	"strings"
+	"time"
+
+	"log/slog"
+
+	geo "github.com/kellydunn/golang-geo"
+)
+
+type SimulatorConfig struct {
+	// Vehicles is the size of the fleet, spread evenly over the organizations.
+	Vehicles int
+	// Organizations is how many organizations of the catalog get vehicles, the ones with
+	// geofences are picked first.
+	Organizations int
+	// Catalog holds the organizations and geofences, data.DefaultConfig() when nil.
+	Catalog *data.Config
+	// Seed makes a run reproducible, the same seed always produces the same events.
+	Seed int64
+	// Routes are the polylines vehicles drive along, looping back to the start. When empty
+	// every vehicle loops through the geofences of its organization.
+	Routes [][]*geo.Point
+	// TickInterval is the simulated time between two reports of a vehicle.
+	TickInterval time.Duration
+	// Speed is how much faster than real time the simulation runs, 0 runs as fast as possible.
+	Speed float64
+	// StartTime is the simulated time of the first tick, zero starts at the current time. Runs
+	// are only reproducible with a fixed start time.
+	StartTime time.Time
+	// StopSpacingMeters is the distance between stops, route vertices are always stops.
+	StopSpacingMeters float64
+	// DwellTime is how long doors stay open at a stop, without it vehicles pass stops.
+	DwellTime time.Duration
+}
+
+var DefaultSimulatorConfig = SimulatorConfig{
+	Vehicles:          20,
+	Organizations:     5,
+	Seed:              1,
+	TickInterval:      1 * time.Second,
+	Speed:             1,
+	StopSpacingMeters: 500,
+	DwellTime:         20 * time.Second,
+}
+
+// simulatedAccuracyMeters is the accuracy radius reported with every simulated fix.
+const simulatedAccuracyMeters = 5.0
+
+// LoadRoutes reads polylines from a JSON file holding an array of routes, each one an
+// array of [latitude, longitude] pairs.
+func LoadRoutes(path string) ([][]*geo.Point, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw [][][2]float64
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	routes := make([][]*geo.Point, 0, len(raw))
+	for i, line := range raw {
+		if len(line) < 2 {
+			return nil, fmt.Errorf("%v: route %v needs at least two points", path, i)
+		}
+
+		route := make([]*geo.Point, 0, len(line))
+		for _, point := range line {
+			route = append(route, geo.NewPoint(point[0], point[1]))
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// SimulatorSource drives a synthetic fleet and emits the same events as the HFP feed:
+// positions, stop arrivals, departures and passes and door openings and closings.
+type SimulatorSource struct {
+	feed
+	config SimulatorConfig
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSimulatorSource(config SimulatorConfig) *SimulatorSource {
+	if config.TickInterval <= 0 {
+		config.TickInterval = DefaultSimulatorConfig.TickInterval
+	}
+	if config.StartTime.IsZero() {
+		config.StartTime = time.Now()
+	}
+	if config.StopSpacingMeters <= 0 {
+		config.StopSpacingMeters = DefaultSimulatorConfig.StopSpacingMeters
+	}
+	if config.Catalog == nil {
+		config.Catalog = data.DefaultConfig()
+	}
+
+	return &SimulatorSource{
+		feed:   newFeed(),
+		config: config,
+	}
+}
+
+func (s *SimulatorSource) Start(ctx context.Context) error {
+	vehicles, err := s.createFleet()
+	if err != nil {
+		return err
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+
+		now := s.config.StartTime
+		for {
+			for _, vehicle := range vehicles {
+				for _, event := range vehicle.step(now, s.config) {
+					select {
+					case s.events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+
+			now = now.Add(s.config.TickInterval)
+			if s.config.Speed > 0 {
+				select {
+				case <-time.After(time.Duration(float64(s.config.TickInterval) / s.config.Speed)):
+				case <-ctx.Done():
+					return
+				}
+			} else if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	slog.Info("Simulator started", "vehicles", len(vehicles), "seed", s.config.Seed)
+	return nil
+}
+
+func (s *SimulatorSource) Stop() error {
+	if s.cancel == nil {
+		return nil
+	}
+
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *SimulatorSource) createFleet() ([]*simulatedVehicle, error) {
+	orgs := simulatedOrganizations(s.config.Catalog, s.config.Organizations)
+	if len(orgs) == 0 || s.config.Vehicles <= 0 {
+		return nil, fmt.Errorf("simulator needs at least one organization and one vehicle")
+	}
+
+	random := rand.New(rand.NewSource(s.config.Seed))
+	vehicles := make([]*simulatedVehicle, 0, s.config.Vehicles)
+
+	for i := 0; i < s.config.Vehicles; i++ {
+		org := orgs[i%len(orgs)]
+
+		var route []*geo.Point
+		if len(s.config.Routes) > 0 {
+			route = s.config.Routes[i%len(s.config.Routes)]
+		} else {
+			route = geofenceRoute(s.config.Catalog, org, random)
+		}
+		if len(route) < 2 {
+			return nil, fmt.Errorf("simulated vehicles of organization %v have no route, configure geofences or routes", org.Id)
+		}
+
+		vehicle := &simulatedVehicle{
+			orgId:     org.Id,
+			number:    fmt.Sprintf("%05d", 90000+i),
+			line:      fmt.Sprintf("S%v", i%len(orgs)+1),
+			route:     closeLoop(route),
+			speed:     8 + random.Float64()*6,
+			occupancy: int32(random.Intn(60)),
+			random:    random,
+		}
+		// spread the fleet over the route instead of starting everyone at the first stop
+		vehicle.segment = random.Intn(len(vehicle.route) - 1)
+		vehicle.nextStop = math.Min(s.config.StopSpacingMeters, vehicle.segmentLength())
+
+		vehicles = append(vehicles, vehicle)
+	}
+
+	return vehicles, nil
+}
+
+// simulatedOrganizations picks the organizations with geofences first, then the rest, by id.
+func simulatedOrganizations(catalog *data.Config, count int) []*data.Organization {
+	orgs := catalog.SortedOrganizations()
+
+	sort.Slice(orgs, func(i, j int) bool {
+		if (len(orgs[i].Geofences) > 0) != (len(orgs[j].Geofences) > 0) {
+			return len(orgs[i].Geofences) > 0
+		}
+		return orgs[i].Id < orgs[j].Id
+	})
+
+	if count > 0 && count < len(orgs) {
+		orgs = orgs[:count]
+	}
+	return orgs
+}
+
+// geofenceRoute visits the centre of every geofence of the organization, or of every known
+// geofence when the organization has none, in a random order.
+func geofenceRoute(catalog *data.Config, org *data.Organization, random *rand.Rand) []*geo.Point {
+	geofences := org.Geofences
+	if len(geofences) == 0 {
+		names := make([]string, 0, len(catalog.Geofences))
+		for name := range catalog.Geofences {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			geofences = append(geofences, catalog.Geofences[name])
+		}
+	}
+
+	route := make([]*geo.Point, 0, len(geofences)+1)
+	for _, i := range random.Perm(len(geofences)) {
+		shape, err := geofences[i].Shape()
+		if err != nil {
+			continue
+		}
+		route = append(route, geo.NewPoint(shape.BoundingBox().Centre()))
+	}
+
+	// a single geofence gives a route that drives out of the zone and back
+	if len(route) == 1 {
+		route = append(route, route[0].PointAtDistanceAndBearing(3, float64(random.Intn(360))))
+	}
+	return route
+}
+
+func closeLoop(route []*geo.Point) []*geo.Point {
+	loop := make([]*geo.Point, 0, len(route)+1)
+	loop = append(loop, route...)
+	return append(loop, route[0])
+}
+
+type simulatedVehicle struct {
+	orgId     string
+	number    string
+	line      string
+	route     []*geo.Point
+	segment   int
+	offset    float64
+	nextStop  float64
+	dwell     time.Duration
+	stops     int
+	odometer  float64
+	speed     float64
+	occupancy int32
+	random    *rand.Rand
+}
+
+func (v *simulatedVehicle) segmentLength() float64 {
+	return v.route[v.segment].GreatCircleDistance(v.route[v.segment+1]) * 1000
+}
+
+func (v *simulatedVehicle) bearing() float64 {
+	return v.route[v.segment].BearingTo(v.route[v.segment+1])
+}
+
+// step advances the vehicle by one tick and returns the events it reported.
+func (v *simulatedVehicle) step(now time.Time, config SimulatorConfig) []*Event {
+	events := make([]*Event, 0, 3)
+
+	if v.dwell > 0 {
+		v.dwell -= config.TickInterval
+		if v.dwell > 0 {
+			return append(events, v.event(shared.VehicleEvent_VP, now, 0, true))
+		}
+
+		events = append(events,
+			v.event(shared.VehicleEvent_DOC, now, 0, false),
+			v.event(shared.VehicleEvent_DEP, now, 0, false))
+		v.leaveStop(config)
+		return events
+	}
+
+	travelled := v.speed * config.TickInterval.Seconds()
+	if v.offset+travelled >= v.nextStop {
+		v.odometer += v.nextStop - v.offset
+		v.offset = v.nextStop
+		v.stops++
+
+		if config.DwellTime <= 0 {
+			v.leaveStop(config)
+			return append(events, v.event(shared.VehicleEvent_PAS, now, v.speed, false))
+		}
+
+		v.dwell = config.DwellTime
+		v.occupancy = int32(max(0, min(100, int(v.occupancy)+v.random.Intn(21)-10)))
+		return append(events,
+			v.event(shared.VehicleEvent_ARR, now, 0, false),
+			v.event(shared.VehicleEvent_DOO, now, 0, true))
+	}
+
+	v.offset += travelled
+	v.odometer += travelled
+	return append(events, v.event(shared.VehicleEvent_VP, now, v.speed, false))
+}
+
+func (v *simulatedVehicle) leaveStop(config SimulatorConfig) {
+	if v.offset >= v.segmentLength() {
+		v.segment = (v.segment + 1) % (len(v.route) - 1)
+		v.offset = 0
+	}
+	v.nextStop = math.Min(v.offset+config.StopSpacingMeters, v.segmentLength())
+}
+
+func (v *simulatedVehicle) event(kind string, now time.Time, speed float64, doorsOpen bool) *Event {
+	point := v.route[v.segment].PointAtDistanceAndBearing(v.offset/1000, v.bearing())
+
+	latitude, longitude := point.Lat(), point.Lng()
+	heading := int32(math.Mod(v.bearing()+360, 360))
+	doorState := int32(0)
+	if doorsOpen {
+		doorState = 1
+	}
+	timestamp := now
+	delay := int32(0)
+	odometer := int64(v.odometer)
+	occupancy := v.occupancy
+	stop := StopId(fmt.Sprintf("%v%04d", v.orgId, v.stops))
+	route := v.line
+	operatingDay := now.Format(time.DateOnly)
+	locationSource := "GPS"
+	accuracy := simulatedAccuracyMeters
+
+	payload := &Payload{
+		Longitude:      &longitude,
+		Latitude:       &latitude,
+		Heading:        &heading,
+		DoorState:      &doorState,
+		Timestamp:      &timestamp,
+		Speed:          &speed,
+		Delay:          &delay,
+		Occupancy:      &occupancy,
+		Odometer:       &odometer,
+		Designation:    &v.line,
+		Stop:           &stop,
+		Route:          &route,
+		OperatingDay:   &operatingDay,
+		LocationSource: &locationSource,
+		AccuracyMeters: &accuracy,
+	}
+
+	event := &Event{
+		VehicleId:     v.orgId + "." + v.number,
+		OperatorId:    v.orgId,
+		TransportMode: "bus",
+		Topic: &Topic{
+			Prefix:        "hfp",
+			Version:       "v2",
+			JourneyType:   "journey",
+			TemporalType:  "ongoing",
+			EventType:     strings.ToLower(kind),
+			TransportMode: "bus",
+			OperatorId:    v.orgId,
+			VehicleNumber: v.number,
+			RouteId:       v.line,
+			DirectionId:   "1",
+			Headsign:      "Simulator",
+		},
+	}
+
+	switch kind {
+	case shared.VehicleEvent_VP:
+		event.VehiclePosition = payload
+	case shared.VehicleEvent_DOO:
+		event.DoorOpen = payload
+	case shared.VehicleEvent_DOC:
+		event.DoorClosed = payload
+	case shared.VehicleEvent_ARR:
+		event.Arrival = payload
+	case shared.VehicleEvent_DEP:
+		event.Departure = payload
+	case shared.VehicleEvent_PAS:
+		event.PassedStop = payload
+	}
+
+	return event
+}
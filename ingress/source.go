@@ -0,0 +1,50 @@
+package ingress
+
+import "context"
+
+// Source produces vehicle events from a feed such as the HSL MQTT broker or a file on disk.
+type Source interface {
+	// Start connects to the feed and begins producing events.
+	Start(ctx context.Context) error
+	// Stop disconnects from the feed. No events are produced after Stop returns.
+	Stop() error
+	// Events delivers parsed events in the order they were received.
+	Events() <-chan *Event
+	// Errors reports non-fatal problems such as malformed messages.
+	Errors() <-chan error
+}
+
+const (
+	eventBufferSize = 1024
+	errorBufferSize = 64
+)
+
+// feed holds the channels shared by all Source implementations.
+type feed struct {
+	events chan *Event
+	errors chan error
+}
+
+func newFeed() feed {
+	return feed{
+		events: make(chan *Event, eventBufferSize),
+		errors: make(chan error, errorBufferSize),
+	}
+}
+
+func (f *feed) Events() <-chan *Event {
+	return f.events
+}
+
+func (f *feed) Errors() <-chan error {
+	return f.errors
+}
+
+// reportError blocks until the error is read or ctx is cancelled, so no error is lost
+// while the source is being consumed.
+func (f *feed) reportError(ctx context.Context, err error) {
+	select {
+	case f.errors <- err:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,131 @@
+package ingress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	transportModes = map[string]struct{}{
+		"bus": {}, "tram": {}, "train": {}, "ferry": {}, "metro": {}, "ubus": {}, "robot": {},
+	}
+
+	topicEventTypes = map[string]struct{}{
+		"vp": {}, "due": {}, "arr": {}, "dep": {}, "ars": {}, "pde": {}, "pas": {}, "wait": {},
+		"doo": {}, "doc": {}, "tlr": {}, "tla": {}, "da": {}, "dout": {}, "ba": {}, "bout": {},
+		"vja": {}, "vjout": {},
+	}
+)
+
+// TopicFilter selects a subset of the HFP feed, empty fields match everything.
+type TopicFilter struct {
+	EventType     string
+	TransportMode string
+	OperatorId    string
+	QoS           byte
+}
+
+// DefaultTopicFilters subscribes to the positions of buses, as the app always did.
+var DefaultTopicFilters = TopicFilters{{EventType: "vp", TransportMode: "bus"}}
+
+// Topic builds the MQTT subscription topic for the filter.
+func (f TopicFilter) Topic() string {
+	return fmt.Sprintf("/hfp/v2/journey/ongoing/%v/%v/%v/#",
+		wildcard(f.EventType), wildcard(f.TransportMode), wildcard(f.OperatorId))
+}
+
+func (f TopicFilter) String() string {
+	parts := make([]string, 0, 4)
+	if f.EventType != "" {
+		parts = append(parts, "event="+f.EventType)
+	}
+	if f.TransportMode != "" {
+		parts = append(parts, "mode="+f.TransportMode)
+	}
+	if f.OperatorId != "" {
+		parts = append(parts, "operator="+f.OperatorId)
+	}
+	parts = append(parts, fmt.Sprintf("qos=%v", f.QoS))
+	return strings.Join(parts, ",")
+}
+
+// ParseTopicFilter parses a filter such as "mode=tram,event=vp,operator=0040,qos=1".
+func ParseTopicFilter(s string) (TopicFilter, error) {
+	filter := TopicFilter{}
+
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || value == "" {
+			return filter, fmt.Errorf("invalid topic filter field %q", field)
+		}
+
+		switch key {
+		case "event":
+			if _, ok := topicEventTypes[value]; !ok {
+				return filter, fmt.Errorf("unknown event type %q", value)
+			}
+			filter.EventType = value
+		case "mode":
+			if _, ok := transportModes[value]; !ok {
+				return filter, fmt.Errorf("unknown transport mode %q", value)
+			}
+			filter.TransportMode = value
+		case "operator":
+			if strings.ContainsAny(value, "/#+") {
+				return filter, fmt.Errorf("invalid operator id %q", value)
+			}
+			filter.OperatorId = value
+		case "qos":
+			qos, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || qos > 2 {
+				return filter, fmt.Errorf("invalid qos %q", value)
+			}
+			filter.QoS = byte(qos)
+		default:
+			return filter, fmt.Errorf("unknown topic filter field %q", key)
+		}
+	}
+
+	return filter, nil
+}
+
+// TopicFilters implements flag.Value so filters can be given as repeated command line flags.
+type TopicFilters []TopicFilter
+
+func (f *TopicFilters) String() string {
+	parts := make([]string, 0, len(*f))
+	for _, filter := range *f {
+		parts = append(parts, filter.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (f *TopicFilters) Set(s string) error {
+	filter, err := ParseTopicFilter(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, filter)
+	return nil
+}
+
+// Subscriptions maps every filter's topic to its QoS, when two filters share a topic the
+// highest QoS wins.
+func (f TopicFilters) Subscriptions() map[string]byte {
+	subscriptions := make(map[string]byte, len(f))
+	for _, filter := range f {
+		topic := filter.Topic()
+		if qos, ok := subscriptions[topic]; !ok || filter.QoS > qos {
+			subscriptions[topic] = filter.QoS
+		}
+	}
+	return subscriptions
+}
+
+func wildcard(s string) string {
+	if s == "" {
+		return "+"
+	}
+	return s
+}
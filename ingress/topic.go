@@ -0,0 +1,120 @@
+package ingress
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	journeyTypes  = map[string]struct{}{"journey": {}, "deadrun": {}, "signoff": {}}
+	temporalTypes = map[string]struct{}{"ongoing": {}, "upcoming": {}}
+)
+
+// Topic is a parsed HFP topic.
+//
+//	/<prefix>/<version>/<journey_type>/<temporal_type>/<event_type>/<transport_mode>/<operator_id>/<vehicle_number>/<route_id>/<direction_id>/<headsign>/<start_time>/<next_stop>/<geohash_level>/<geohash>
+//
+// Only the part up to the vehicle number is mandatory, deadrun and signoff topics end there.
+type Topic struct {
+	Prefix        string
+	Version       string
+	JourneyType   string
+	TemporalType  string
+	EventType     string
+	TransportMode string
+	OperatorId    string
+	VehicleNumber string
+	RouteId       string
+	DirectionId   string
+	Headsign      string
+	StartTime     string
+	NextStop      string
+	GeohashLevel  string
+	// Geohash keeps its own slashes, e.g. "60;24/19/73/45".
+	Geohash string
+}
+
+// ParseTopic validates and splits an HFP topic.
+func ParseTopic(topic string) (*Topic, error) {
+	parts := strings.Split(topic, "/")
+	if len(parts) < 9 {
+		return nil, fmt.Errorf("topic %q is too short", topic)
+	}
+	if parts[0] != "" {
+		return nil, fmt.Errorf("topic %q must start with a slash", topic)
+	}
+
+	t := &Topic{
+		Prefix:        parts[1],
+		Version:       parts[2],
+		JourneyType:   parts[3],
+		TemporalType:  parts[4],
+		EventType:     parts[5],
+		TransportMode: parts[6],
+		OperatorId:    parts[7],
+		VehicleNumber: parts[8],
+	}
+
+	optional := []*string{&t.RouteId, &t.DirectionId, &t.Headsign, &t.StartTime, &t.NextStop, &t.GeohashLevel}
+	for i, field := range optional {
+		if len(parts) > 9+i {
+			*field = parts[9+i]
+		}
+	}
+	if len(parts) > 15 {
+		t.Geohash = strings.TrimRight(strings.Join(parts[15:], "/"), "/")
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, fmt.Errorf("topic %q: %w", topic, err)
+	}
+	return t, nil
+}
+
+func (t *Topic) validate() error {
+	if t.Prefix != "hfp" {
+		return fmt.Errorf("unknown prefix %q", t.Prefix)
+	}
+	if t.Version != "v2" {
+		return fmt.Errorf("unsupported version %q", t.Version)
+	}
+	if _, ok := journeyTypes[t.JourneyType]; !ok {
+		return fmt.Errorf("unknown journey type %q", t.JourneyType)
+	}
+	if _, ok := temporalTypes[t.TemporalType]; !ok {
+		return fmt.Errorf("unknown temporal type %q", t.TemporalType)
+	}
+	if _, ok := topicEventTypes[t.EventType]; !ok {
+		return fmt.Errorf("unknown event type %q", t.EventType)
+	}
+	if _, ok := transportModes[t.TransportMode]; !ok {
+		return fmt.Errorf("unknown transport mode %q", t.TransportMode)
+	}
+	if !isDigits(t.OperatorId) {
+		return fmt.Errorf("invalid operator id %q", t.OperatorId)
+	}
+	if !isDigits(t.VehicleNumber) {
+		return fmt.Errorf("invalid vehicle number %q", t.VehicleNumber)
+	}
+	if t.DirectionId != "" && t.DirectionId != "1" && t.DirectionId != "2" {
+		return fmt.Errorf("invalid direction id %q", t.DirectionId)
+	}
+	return nil
+}
+
+// VehicleId identifies a vehicle across operators.
+func (t *Topic) VehicleId() string {
+	return t.OperatorId + "." + t.VehicleNumber
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package ingress
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		want  Topic
+	}{
+		{
+			topic: "/hfp/v2/journey/ongoing/vp/bus/0022/00854/4555B/2/Leppävaara/19:56/4150264/5/60;24/28/65/06",
+			want: Topic{
+				Prefix: "hfp", Version: "v2", JourneyType: "journey", TemporalType: "ongoing", EventType: "vp",
+				TransportMode: "bus", OperatorId: "0022", VehicleNumber: "00854", RouteId: "4555B", DirectionId: "2",
+				Headsign: "Leppävaara", StartTime: "19:56", NextStop: "4150264", GeohashLevel: "5", Geohash: "60;24/28/65/06",
+			},
+		},
+		{
+			topic: "/hfp/v2/journey/ongoing/doo/tram/0040/00431/1009/1/Kauppatori/11:32/1301112/4/60;24/19/72/",
+			want: Topic{
+				Prefix: "hfp", Version: "v2", JourneyType: "journey", TemporalType: "ongoing", EventType: "doo",
+				TransportMode: "tram", OperatorId: "0040", VehicleNumber: "00431", RouteId: "1009", DirectionId: "1",
+				Headsign: "Kauppatori", StartTime: "11:32", NextStop: "1301112", GeohashLevel: "4", Geohash: "60;24/19/72",
+			},
+		},
+		{
+			// a vehicle between journeys has neither route nor geohash
+			topic: "/hfp/v2/journey/ongoing/vp/metro/0050/00105/////////",
+			want: Topic{
+				Prefix: "hfp", Version: "v2", JourneyType: "journey", TemporalType: "ongoing", EventType: "vp",
+				TransportMode: "metro", OperatorId: "0050", VehicleNumber: "00105",
+			},
+		},
+		{
+			topic: "/hfp/v2/deadrun/upcoming/vp/bus/0012/01825",
+			want: Topic{
+				Prefix: "hfp", Version: "v2", JourneyType: "deadrun", TemporalType: "upcoming", EventType: "vp",
+				TransportMode: "bus", OperatorId: "0012", VehicleNumber: "01825",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.topic, func(t *testing.T) {
+			topic, err := ParseTopic(test.topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *topic != test.want {
+				t.Errorf("got %+v, want %+v", *topic, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTopicRejects(t *testing.T) {
+	tests := []struct {
+		topic string
+		err   string
+	}{
+		{"/hfp/v2/journey/ongoing/vp/bus/0022", "too short"},
+		{"hfp/v2/journey/ongoing/vp/bus/0022/00854/4555B", "must start with a slash"},
+		{"/gtfsrt/v2/journey/ongoing/vp/bus/0022/00854", "unknown prefix"},
+		{"/hfp/v1/journey/ongoing/vp/bus/0022/00854", "unsupported version"},
+		{"/hfp/v2/trip/ongoing/vp/bus/0022/00854", "unknown journey type"},
+		{"/hfp/v2/journey/past/vp/bus/0022/00854", "unknown temporal type"},
+		{"/hfp/v2/journey/ongoing/xyz/bus/0022/00854", "unknown event type"},
+		{"/hfp/v2/journey/ongoing/vp/zeppelin/0022/00854", "unknown transport mode"},
+		{"/hfp/v2/journey/ongoing/vp/bus/HSL/00854", "invalid operator id"},
+		{"/hfp/v2/journey/ongoing/vp/bus/0022/", "invalid vehicle number"},
+		{"/hfp/v2/journey/ongoing/vp/bus/0022/00854/4555B/0", "invalid direction id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.topic, func(t *testing.T) {
+			_, err := ParseTopic(test.topic)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestTopicVehicleId(t *testing.T) {
+	topic, err := ParseTopic("/hfp/v2/journey/ongoing/vp/bus/0022/00854")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := topic.VehicleId(); id != "0022.00854" {
+		t.Errorf("got vehicle id %q, want 0022.00854", id)
+	}
+}
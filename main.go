@@ -2,13 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"realtimemap-temporal/data"
+	"realtimemap-temporal/deadletter"
 	"realtimemap-temporal/ingress"
+	"realtimemap-temporal/pipeline"
 	"realtimemap-temporal/server"
 	"realtimemap-temporal/shared"
 	"realtimemap-temporal/workflow"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"log/slog"
 
@@ -18,7 +27,152 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+var (
+	sourceFlag = flag.String("source", "mqtt", "ingress source: mqtt, file, replay, simulator, gtfsrt or memory")
+	fileFlag   = flag.String("file", "", "path of the file read by the file and replay sources")
+	recordFlag = flag.String("record", "", "capture raw MQTT traffic into this gzip file")
+	speedFlag  = flag.Float64("replay-speed", 1, "replay speed multiplier, 0 replays as fast as possible")
+
+	simVehiclesFlag = flag.Int("sim-vehicles", ingress.DefaultSimulatorConfig.Vehicles, "number of simulated vehicles")
+	simOrgsFlag     = flag.Int("sim-organizations", ingress.DefaultSimulatorConfig.Organizations, "number of organizations the simulated vehicles belong to")
+	simSeedFlag     = flag.Int64("sim-seed", ingress.DefaultSimulatorConfig.Seed, "seed of the simulation")
+	simSpeedFlag    = flag.Float64("sim-speed", ingress.DefaultSimulatorConfig.Speed, "simulation speed multiplier, 0 runs as fast as possible")
+	simRoutesFlag   = flag.String("sim-routes", "", "JSON file with the polylines simulated vehicles drive along")
+	simStartFlag    = flag.String("sim-start", "", `simulated RFC 3339 time of the first tick such as "2023-10-02T07:00:00Z", the current time when empty`)
+
+	gtfsrtURLFlag    = flag.String("gtfsrt-url", "", "URL of a GTFS-Realtime VehiclePositions feed")
+	gtfsrtFilesFlag  = flag.String("gtfsrt-files", "", "glob of local GTFS-Realtime feed files, read instead of polling a URL")
+	gtfsrtPollFlag   = flag.Duration("gtfsrt-poll", ingress.DefaultGTFSRTPollInterval, "GTFS-Realtime polling interval")
+	gtfsrtAgencyFlag = flag.String("gtfsrt-agency", "", "organization id of the vehicles in the GTFS-Realtime feed")
+	gtfsrtRoutesFlag = flag.String("gtfsrt-route-agencies", "", `comma separated route to organization overrides such as "550:0012,551:0018"`)
+	gtfsrtModeFlag   = flag.String("gtfsrt-mode", "bus", "transport mode of the vehicles in the GTFS-Realtime feed")
+
+	workersFlag  = flag.Int("ingress-workers", pipeline.DefaultDispatcherConfig.Workers, "number of workers signalling Temporal")
+	queueFlag    = flag.Int("ingress-queue", pipeline.DefaultDispatcherConfig.QueueSize, "pending signals per worker")
+	overflowFlag = flag.String("ingress-overflow", "block", "what to do when a worker queue is full: block or drop")
+
+	orderingFlag  = flag.String("ordering", string(shared.OrderingMode_STRICT), "drop positions older than the last accepted one: strict or tolerance")
+	toleranceFlag = flag.Duration("ordering-tolerance", 0, "how late a position may be in tolerance mode")
+	dedupTTLFlag  = flag.Duration("ordering-ttl", pipeline.DefaultDedupTTL, "how long the ordering, validation and downsampling state of a silent vehicle is kept, 0 keeps it forever")
+
+	serviceAreaFlag     = flag.String("service-area", "59.8,23.9,60.8,25.9", "minLat,minLng,maxLat,maxLng of accepted positions, empty disables the check")
+	maxSpeedFlag        = flag.Float64("max-speed", 50, "maximum plausible speed in m/s between two fixes, 0 disables the check")
+	locationSourcesFlag = flag.String("location-sources", "", `comma separated HFP location sources to trust such as "GPS,ODO", empty trusts all`)
+	maxAccuracyFlag     = flag.Float64("max-accuracy", 50, "largest accepted accuracy radius in meters of fixes that report one, 0 disables the check")
+
+	deadLetterFlag     = flag.String("deadletter", "", "where rejected ingress messages are stored besides memory: file or redis")
+	deadLetterFileFlag = flag.String("deadletter-file", "deadletter.ndjson", "NDJSON file of the file dead letter sink, rotated at 10MB")
+
+	downsampleFlag = flag.String("downsample", "", `default downsampling policy such as "interval=5s,distance=50,heading=30", empty passes every position`)
+
+	configFlag      = flag.String("config", "", "YAML, JSON or GeoJSON file with the organizations and geofences, reloaded on SIGHUP, the built in ones when empty")
+	configPruneFlag = flag.Bool("config-prune", false, "delete the organizations and geofences started from the config once they're removed from it, and unassign removed geofences")
+
+	roleFlag       = flag.String("role", "all", "what this instance runs: all, http (API only) or ingest (ingress and workflow setup only)")
+	httpAddrFlag   = flag.String("http-addr", ":12345", "address the HTTP API listens on, -role ingest serves only health, dead letters and metrics on it")
+	instanceFlag   = flag.Int("instance", 0, "index of this ingest instance, counted from 0")
+	instancesFlag  = flag.Int("instances", 1, "number of ingest instances the vehicles are partitioned over")
+	shareGroupFlag = flag.String("share-group", "", "MQTT shared subscription group, the broker splits the feed over the group members")
+
+	entryBufferFlag = flag.Float64("geofence-entry-buffer", workflow.DefaultGeofenceHysteresis.EntryBufferMeters, "meters a vehicle must be inside the edge of a geofence to enter it")
+	exitBufferFlag  = flag.Float64("geofence-exit-buffer", workflow.DefaultGeofenceHysteresis.ExitBufferMeters, "meters a vehicle must be outside the edge of a geofence to exit it")
+	entryFixesFlag  = flag.Int("geofence-entry-fixes", workflow.DefaultGeofenceHysteresis.EntryFixes, "consecutive positions needed to enter a geofence")
+	exitFixesFlag   = flag.Int("geofence-exit-fixes", workflow.DefaultGeofenceHysteresis.ExitFixes, "consecutive positions needed to exit a geofence")
+	dwellFlag       = flag.Duration("geofence-dwell", workflow.DefaultDwellThreshold, "time in a geofence before a DWELL notification, 0 disables it")
+	vehicleTTLFlag  = flag.Duration("geofence-vehicle-ttl", workflow.DefaultVehicleTTL, "silence after which a vehicle is evicted from a geofence with a TIMEOUT EXIT, 0 disables it")
+
+	topicFilters        ingress.TopicFilters
+	downsampleOverrides = pipeline.OrganizationPolicies{}
+
+	// config is swapped on reload while events are mapped
+	config atomic.Pointer[data.Config]
+)
+
+func init() {
+	flag.Var(&topicFilters, "topic-filter", `HFP subscription filter such as "mode=tram,event=vp,operator=0040,qos=1", can be repeated, bus positions when none is given`)
+	flag.Var(downsampleOverrides, "downsample-org", `downsampling policy of one organization such as "0012:interval=10s,distance=100", can be repeated`)
+}
+
 func main() {
+	flag.Parse()
+
+	if *roleFlag != "all" && *roleFlag != "http" && *roleFlag != "ingest" {
+		panic(fmt.Sprintf("unknown role %q", *roleFlag))
+	}
+	ingests, serves := *roleFlag != "http", *roleFlag != "ingest"
+
+	initialConfig, err := loadConfig()
+	if err != nil {
+		panic(err)
+	}
+	config.Store(initialConfig)
+
+	var source ingress.Source
+	if ingests {
+		source, err = newSource()
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	partitioner, err := pipeline.NewPartitioner(*instanceFlag, *instancesFlag)
+	if err != nil {
+		panic(err)
+	}
+
+	dispatcherConfig := pipeline.DefaultDispatcherConfig
+	dispatcherConfig.Workers = *workersFlag
+	dispatcherConfig.QueueSize = *queueFlag
+	dispatcherConfig.Overflow, err = pipeline.ParseOverflowPolicy(*overflowFlag)
+	if err != nil {
+		panic(err)
+	}
+	dispatcher := pipeline.NewDispatcher(dispatcherConfig)
+
+	ordering := shared.OrderingPolicy{ToleranceMillis: toleranceFlag.Milliseconds()}
+	ordering.Mode, err = shared.ParseOrderingMode(*orderingFlag)
+	if err != nil {
+		panic(err)
+	}
+	deduplicator := pipeline.NewDeduplicator(ordering, *dedupTTLFlag)
+	vehicleInput := &workflow.VehicleInput{Ordering: ordering}
+
+	validatorConfig := pipeline.ValidatorConfig{
+		MaxSpeedMetersPerSecond: *maxSpeedFlag,
+		MaxAccuracyMeters:       *maxAccuracyFlag,
+	}
+	if *serviceAreaFlag != "" {
+		serviceArea, err := shared.ParseBoundingBox(*serviceAreaFlag)
+		if err != nil {
+			panic(err)
+		}
+		validatorConfig.ServiceArea = &serviceArea
+	}
+	if *locationSourcesFlag != "" {
+		validatorConfig.AllowedLocationSources = strings.Split(*locationSourcesFlag, ",")
+	}
+	validator := pipeline.NewValidator(validatorConfig, *dedupTTLFlag)
+
+	downsamplePolicy := pipeline.DownsamplePolicy{}
+	if *downsampleFlag != "" {
+		downsamplePolicy, err = pipeline.ParseDownsamplePolicy(*downsampleFlag)
+		if err != nil {
+			panic(err)
+		}
+	}
+	downsampler := pipeline.NewDownsampler(downsamplePolicy, downsampleOverrides, *dedupTTLFlag)
+
+	geofenceSettings := workflow.GeofenceSettings{
+		Hysteresis: workflow.GeofenceHysteresis{
+			EntryBufferMeters: *entryBufferFlag,
+			ExitBufferMeters:  *exitBufferFlag,
+			EntryFixes:        *entryFixesFlag,
+			ExitFixes:         *exitFixesFlag,
+		},
+		DwellThreshold: *dwellFlag,
+		VehicleTTL:     *vehicleTTLFlag,
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	stopOnSignals(cancel)
 
@@ -40,17 +194,63 @@ func main() {
 	})
 	defer redisClient.Close()
 
-	srv := server.NewHttpServer(ctx, redisClient, temporalClient)
-	srvDone := srv.ListenAndServe()
-
-	err = workflow.InitOrganization(ctx, temporalClient)
+	deadLetters, err := newDeadLetterStore(redisClient)
 	if err != nil {
 		panic(err)
 	}
+	defer deadLetters.Close()
 
-	err = workflow.InitGeofence(ctx, temporalClient)
-	if err != nil {
-		panic(err)
+	// the Redis stream is shared by every instance, memory only holds what this instance rejected
+	var deadLetterReader deadletter.Reader
+	if *deadLetterFlag == "redis" {
+		deadLetterReader = deadletter.NewRedisSink(redisClient, deadletter.DefaultStream, deadletter.DefaultStreamMaxLen)
+	} else if ingests {
+		deadLetterReader = deadLetters
+	}
+
+	health := ingress.UnknownHealth
+	if ingests {
+		health, _ = source.(ingress.HealthReporter)
+	}
+
+	var srv *server.Server
+	if serves {
+		srv = server.NewHttpServer(ctx, *httpAddrFlag, redisClient, temporalClient, health, deadLetterReader, geofenceSettings)
+	} else {
+		srv = server.NewIngestServer(ctx, *httpAddrFlag, health, deadLetterReader)
+	}
+	srvDone := srv.ListenAndServe()
+
+	// a single instance reconciles, the others would only repeat its work. Workflows that fail to
+	// reconcile are logged and retried on the next reload.
+	reconciles := ingests && *instanceFlag == 0
+	reconcile := func(desired *data.Config) {
+		if err := workflow.Reconcile(ctx, temporalClient, desired, geofenceSettings, *configPruneFlag); err != nil {
+			slog.Error("Reconciling config failed", "error", err)
+		}
+	}
+
+	// every instance maps events with the config, so every instance reloads it
+	reloadOnSignal(ctx, func() {
+		reloaded, err := loadConfig()
+		if err != nil {
+			slog.Error("Reloading config failed, keeping the current one", "error", err)
+			return
+		}
+		config.Store(reloaded)
+
+		if reconciles {
+			reconcile(reloaded)
+		}
+	})
+
+	if !ingests {
+		<-srvDone
+		return
+	}
+
+	if reconciles {
+		reconcile(config.Load())
 	}
 
 	err = workflow.InitNotification(ctx, temporalClient)
@@ -58,20 +258,206 @@ func main() {
 		panic(err)
 	}
 
-	ingressDone := ingress.ConsumeVehicleEvents(func(e *ingress.Event) {
-		position := mapToPosition(e)
-		if position != nil {
-			err := workflow.InitVehicle(ctx, temporalClient, position)
-			if err != nil {
-				panic(err)
+	dispatcherDone := dispatcher.Start(ctx)
+
+	ingressDone, err := ingress.ConsumeVehicleEvents(source, func(e *ingress.Event) {
+		// without a shared subscription every instance receives the whole feed
+		if !partitioner.Owns(e.VehicleId) {
+			return
+		}
+
+		if kind, _ := e.Kind(); kind == "" {
+			deadLetters.Add(newDeadLetter(e, &pipeline.RejectionError{Reason: deadletter.Reason_UNKNOWN_EVENT, Detail: "payload carries no known event"}))
+			return
+		}
+
+		position, err := mapToPosition(e)
+		if err != nil {
+			deadLetters.Add(newDeadLetter(e, err))
+		} else if position != nil && deduplicator.Accept(position) {
+			if err := validator.Validate(position); err != nil {
+				deadLetters.Add(newDeadLetter(e, err))
+			} else if downsampler.Accept(position) {
+				dispatcher.Submit(position.VehicleId, func(ctx context.Context) error {
+					return workflow.InitVehicle(ctx, temporalClient, vehicleInput, position)
+				})
 			}
 		}
+
+		vehicleEvent, err := mapToVehicleEvent(e)
+		if err != nil {
+			deadLetters.Add(newDeadLetter(e, err))
+		} else if vehicleEvent != nil {
+			dispatcher.Submit(vehicleEvent.VehicleId, func(ctx context.Context) error {
+				return workflow.InitVehicleEvent(ctx, temporalClient, vehicleInput, vehicleEvent)
+			})
+		}
+	}, func(err error) {
+		var parseErr *ingress.ParseError
+		if errors.As(err, &parseErr) {
+			// like events, a malformed message is dead lettered by the instance owning its vehicle,
+			// or by instance 0 when the topic names none
+			if topic, err := ingress.ParseTopic(parseErr.Topic); err == nil {
+				if !partitioner.Owns(topic.VehicleId()) {
+					return
+				}
+			} else if *shareGroupFlag == "" && *instanceFlag != 0 {
+				return
+			}
+
+			deadLetters.Add(&deadletter.Record{
+				Reason:  deadletter.Reason_MALFORMED,
+				Detail:  parseErr.Err.Error(),
+				Topic:   parseErr.Topic,
+				Payload: string(parseErr.Payload),
+			})
+			return
+		}
+		slog.Error("Ingress source error", "error", err)
 	}, ctx)
+	if err != nil {
+		panic(err)
+	}
 
 	<-ingressDone
+	<-dispatcherDone
 	<-srvDone
 }
 
+func newSource() (ingress.Source, error) {
+	switch *sourceFlag {
+	case "mqtt":
+		config := ingress.MQTTConfig{
+			Filters:    topicFilters,
+			ShareGroup: *shareGroupFlag,
+		}
+		if *recordFlag != "" {
+			recorder, err := ingress.NewRecorder(*recordFlag)
+			if err != nil {
+				return nil, err
+			}
+			config.Recorder = recorder
+		}
+		return ingress.NewMQTTSource(config), nil
+	case "file":
+		if *fileFlag == "" {
+			return nil, fmt.Errorf("-file is required for the file source")
+		}
+		return ingress.NewFileSource(*fileFlag), nil
+	case "replay":
+		if *fileFlag == "" {
+			return nil, fmt.Errorf("-file is required for the replay source")
+		}
+		return ingress.NewReplaySource(*fileFlag, *speedFlag), nil
+	case "simulator":
+		simulatorConfig := ingress.DefaultSimulatorConfig
+		simulatorConfig.Vehicles = *simVehiclesFlag
+		simulatorConfig.Organizations = *simOrgsFlag
+		simulatorConfig.Seed = *simSeedFlag
+		simulatorConfig.Speed = *simSpeedFlag
+		simulatorConfig.Catalog = config.Load()
+		if *simStartFlag != "" {
+			start, err := time.Parse(time.RFC3339, *simStartFlag)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -sim-start: %w", err)
+			}
+			simulatorConfig.StartTime = start
+		}
+		if *simRoutesFlag != "" {
+			routes, err := ingress.LoadRoutes(*simRoutesFlag)
+			if err != nil {
+				return nil, err
+			}
+			simulatorConfig.Routes = routes
+		}
+		return ingress.NewSimulatorSource(simulatorConfig), nil
+	case "gtfsrt":
+		config := ingress.GTFSRTConfig{
+			URL:           *gtfsrtURLFlag,
+			PollInterval:  *gtfsrtPollFlag,
+			Files:         *gtfsrtFilesFlag,
+			AgencyId:      *gtfsrtAgencyFlag,
+			RouteAgencies: make(map[string]string),
+			TransportMode: *gtfsrtModeFlag,
+		}
+		if *gtfsrtRoutesFlag != "" {
+			for _, pair := range strings.Split(*gtfsrtRoutesFlag, ",") {
+				route, agency, ok := strings.Cut(pair, ":")
+				if !ok {
+					return nil, fmt.Errorf("invalid route agency %q", pair)
+				}
+				config.RouteAgencies[route] = agency
+			}
+		}
+		return ingress.NewGTFSRTSource(config), nil
+	case "memory":
+		return ingress.NewMemorySource(), nil
+	default:
+		return nil, fmt.Errorf("unknown ingress source %q", *sourceFlag)
+	}
+}
+
+func newDeadLetterStore(redisClient *redis.Client) (*deadletter.Store, error) {
+	switch *deadLetterFlag {
+	case "":
+		return deadletter.NewStore(nil, deadletter.DefaultRecentCapacity), nil
+	case "file":
+		sink, err := deadletter.NewFileSink(*deadLetterFileFlag, deadletter.DefaultMaxFileBytes, deadletter.DefaultMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		return deadletter.NewStore(sink, deadletter.DefaultRecentCapacity), nil
+	case "redis":
+		sink := deadletter.NewRedisSink(redisClient, deadletter.DefaultStream, deadletter.DefaultStreamMaxLen)
+		return deadletter.NewStore(sink, deadletter.DefaultRecentCapacity), nil
+	default:
+		return nil, fmt.Errorf("unknown dead letter sink %q", *deadLetterFlag)
+	}
+}
+
+// newDeadLetter records the raw message behind a rejected event.
+func newDeadLetter(e *ingress.Event, err error) *deadletter.Record {
+	record := &deadletter.Record{
+		Reason:    deadletter.Reason_INVALID_PAYLOAD,
+		Detail:    err.Error(),
+		VehicleId: e.VehicleId,
+		Topic:     e.RawTopic,
+		Payload:   string(e.RawPayload),
+	}
+
+	var rejection *pipeline.RejectionError
+	if errors.As(err, &rejection) {
+		record.Reason = rejection.Reason
+		record.Detail = rejection.Detail
+	}
+	return record
+}
+
+func loadConfig() (*data.Config, error) {
+	if *configFlag == "" {
+		return data.DefaultConfig(), nil
+	}
+	return data.LoadConfig(*configFlag)
+}
+
+// reloadOnSignal calls reload on every SIGHUP until ctx is cancelled.
+func reloadOnSignal(ctx context.Context, reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-sigs:
+				slog.Info("Reloading config", "path", *configFlag)
+				reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 func stopOnSignals(cancel func()) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt)
@@ -82,36 +468,129 @@ func stopOnSignals(cancel func()) {
 	}()
 }
 
-func mapToPosition(e *ingress.Event) *shared.Position {
-	var payload *ingress.Payload
-
-	if e.VehiclePosition != nil {
-		payload = e.VehiclePosition
-	} else if e.DoorOpen != nil {
-		payload = e.DoorOpen
-	} else if e.DoorClosed != nil {
-		payload = e.DoorClosed
-	} else {
-		return nil
+// mapToPosition returns nil without an error for events that don't carry a position.
+func mapToPosition(e *ingress.Event) (*shared.Position, error) {
+	kind, payload := e.Kind()
+	switch kind {
+	case shared.VehicleEvent_VP, shared.VehicleEvent_DOO, shared.VehicleEvent_DOC:
+	default:
+		return nil, nil
 	}
 
 	if !payload.HasValidPosition() {
-		return nil
+		return nil, &pipeline.RejectionError{Reason: deadletter.Reason_MISSING_FIELDS, Detail: "payload misses position fields"}
 	}
 
-	orgName := ""
-	if org, ok := data.AllOrganizations[e.OperatorId]; ok {
-		orgName = org.Name
+	if err := payload.Validate(); err != nil {
+		return nil, &pipeline.RejectionError{Reason: deadletter.Reason_INVALID_PAYLOAD, Detail: err.Error()}
 	}
 
-	return &shared.Position{
-		VehicleId: e.VehicleId,
-		OrgId:     e.OperatorId,
-		OrgName:   orgName,
-		Latitude:  *payload.Latitude,
-		Longitude: *payload.Longitude,
-		Heading:   *payload.Heading,
-		Timestamp: (*payload.Timestamp).UnixMilli(),
-		Speed:     *payload.Speed,
+	position := &shared.Position{
+		VehicleId:     e.VehicleId,
+		OrgId:         e.OperatorId,
+		OrgName:       organizationName(e.OperatorId),
+		TransportMode: e.TransportMode,
+		Event:         kind,
+		Latitude:      *payload.Latitude,
+		Longitude:     *payload.Longitude,
+		Heading:       *payload.Heading,
+		Timestamp:     (*payload.Timestamp).UnixMilli(),
+		Speed:         *payload.Speed,
+		DoorsOpen:     *payload.DoorState == 1,
+	}
+
+	// door events are authoritative even when drst lags behind
+	switch kind {
+	case shared.VehicleEvent_DOO:
+		position.DoorsOpen = true
+	case shared.VehicleEvent_DOC:
+		position.DoorsOpen = false
+	}
+
+	if e.Topic != nil {
+		position.RouteId = e.Topic.RouteId
+		position.DirectionId = e.Topic.DirectionId
+		position.Headsign = e.Topic.Headsign
+		position.StartTime = e.Topic.StartTime
+		position.NextStop = e.Topic.NextStop
+	}
+
+	if payload.Delay != nil {
+		position.Delay = *payload.Delay
+	}
+	if payload.Occupancy != nil {
+		position.Occupancy = *payload.Occupancy
+	}
+	if payload.Odometer != nil {
+		position.Odometer = *payload.Odometer
+	}
+	if payload.Designation != nil {
+		position.Line = *payload.Designation
+	}
+	if payload.Stop != nil {
+		position.StopId = string(*payload.Stop)
+	}
+	if payload.Route != nil && position.RouteId == "" {
+		position.RouteId = *payload.Route
+	}
+	if payload.OperatingDay != nil {
+		position.OperatingDay = *payload.OperatingDay
+	}
+	if payload.Start != nil && position.StartTime == "" {
+		position.StartTime = *payload.Start
 	}
+	if payload.LocationSource != nil {
+		position.LocationSource = *payload.LocationSource
+	}
+	if payload.Acceleration != nil {
+		position.Acceleration = *payload.Acceleration
+	}
+	if payload.AccuracyMeters != nil {
+		position.AccuracyMeters = *payload.AccuracyMeters
+	}
+
+	return position, nil
+}
+
+// mapToVehicleEvent returns nil without an error for events that are positions.
+func mapToVehicleEvent(e *ingress.Event) (*shared.VehicleEvent, error) {
+	kind, payload := e.Kind()
+	switch kind {
+	case "", shared.VehicleEvent_VP, shared.VehicleEvent_DOO, shared.VehicleEvent_DOC:
+		return nil, nil
+	}
+
+	if payload.Timestamp == nil {
+		return nil, &pipeline.RejectionError{Reason: deadletter.Reason_MISSING_FIELDS, Detail: "payload misses tst"}
+	}
+
+	event := &shared.VehicleEvent{
+		Type:          kind,
+		VehicleId:     e.VehicleId,
+		OrgId:         e.OperatorId,
+		OrgName:       organizationName(e.OperatorId),
+		TransportMode: e.TransportMode,
+		Timestamp:     (*payload.Timestamp).UnixMilli(),
+	}
+
+	if e.Topic != nil {
+		event.RouteId = e.Topic.RouteId
+		event.DirectionId = e.Topic.DirectionId
+	}
+	if payload.Designation != nil {
+		event.Line = *payload.Designation
+	}
+	if payload.Stop != nil {
+		event.StopId = string(*payload.Stop)
+	}
+	if payload.Latitude != nil && payload.Longitude != nil {
+		event.Latitude = *payload.Latitude
+		event.Longitude = *payload.Longitude
+	}
+
+	return event, nil
+}
+
+func organizationName(orgId string) string {
+	return config.Load().OrganizationName(orgId)
 }
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+// circuitBreaker opens after threshold consecutive failures and holds every caller back
+// for cooldown. After the cooldown a single caller is let through as a trial, the circuit
+// closes when it succeeds and opens for another cooldown when it fails.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	// changed is closed and replaced whenever the trial ends, waking the callers held back
+	changed chan struct{}
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		changed:   make(chan struct{}),
+	}
+}
+
+// wait blocks while the circuit is open or another caller runs the trial.
+func (b *circuitBreaker) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if !b.open() {
+			b.mu.Unlock()
+			return nil
+		}
+
+		remaining := time.Until(b.openedAt.Add(b.cooldown))
+		if remaining <= 0 && !b.probing {
+			b.probing = true
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		// while the trial runs only its end wakes us up
+		var cooledDown <-chan time.Time
+		if remaining > 0 {
+			cooledDown = time.After(remaining)
+		}
+
+		select {
+		case <-cooledDown:
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.open() {
+		slog.Info("Circuit breaker closed")
+		b.endTrial()
+	}
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.open() {
+		// a failed trial after the cooldown opens the circuit again
+		b.openedAt = time.Now()
+		if b.failures == b.threshold {
+			metrics.Add(metricCircuitOpen, 1)
+			slog.Warn("Circuit breaker opened", "failures", b.failures, "cooldown", b.cooldown)
+		}
+		if b.probing {
+			b.endTrial()
+		}
+	}
+}
+
+func (b *circuitBreaker) open() bool {
+	return b.threshold > 0 && b.failures >= b.threshold
+}
+
+func (b *circuitBreaker) endTrial() {
+	b.probing = false
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testCooldown = 50 * time.Millisecond
+
+// waitWithin returns the error of breaker.wait, or context.DeadlineExceeded when it's still
+// blocked after timeout.
+func waitWithin(breaker *circuitBreaker, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return breaker.wait(ctx)
+}
+
+func TestCircuitBreakerOpensAtThreshold(t *testing.T) {
+	breaker := newCircuitBreaker(3, time.Hour)
+
+	breaker.failure()
+	breaker.failure()
+	if err := waitWithin(breaker, 10*time.Millisecond); err != nil {
+		t.Fatalf("closed circuit held the caller back: %v", err)
+	}
+
+	breaker.failure()
+	if err := waitWithin(breaker, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("open circuit let the caller through: %v", err)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	breaker := newCircuitBreaker(2, time.Hour)
+
+	breaker.failure()
+	breaker.success()
+	breaker.failure()
+	if err := waitWithin(breaker, 10*time.Millisecond); err != nil {
+		t.Fatalf("failures before a success opened the circuit: %v", err)
+	}
+}
+
+func TestCircuitBreakerWithoutThreshold(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Hour)
+
+	for i := 0; i < 100; i++ {
+		breaker.failure()
+	}
+	if err := waitWithin(breaker, 10*time.Millisecond); err != nil {
+		t.Fatalf("circuit without threshold opened: %v", err)
+	}
+}
+
+func TestCircuitBreakerTrialCloses(t *testing.T) {
+	breaker := newCircuitBreaker(1, testCooldown)
+	breaker.failure()
+
+	// the first caller after the cooldown runs the trial
+	if err := waitWithin(breaker, time.Second); err != nil {
+		t.Fatalf("no trial after the cooldown: %v", err)
+	}
+
+	// everyone else waits for it
+	held := make(chan error)
+	go func() {
+		held <- waitWithin(breaker, time.Second)
+	}()
+	select {
+	case err := <-held:
+		t.Fatalf("second caller ran during the trial: %v", err)
+	case <-time.After(2 * testCooldown):
+	}
+
+	breaker.success()
+	if err := <-held; err != nil {
+		t.Fatalf("successful trial didn't release the caller: %v", err)
+	}
+	if err := waitWithin(breaker, 10*time.Millisecond); err != nil {
+		t.Fatalf("successful trial didn't close the circuit: %v", err)
+	}
+}
+
+func TestCircuitBreakerFailedTrialReopens(t *testing.T) {
+	breaker := newCircuitBreaker(1, testCooldown)
+	breaker.failure()
+
+	if err := waitWithin(breaker, time.Second); err != nil {
+		t.Fatalf("no trial after the cooldown: %v", err)
+	}
+
+	held := make(chan error)
+	go func() {
+		held <- waitWithin(breaker, testCooldown/2)
+	}()
+	// a waiting caller wakes up when the trial fails, and is held back for another cooldown
+	failedAt := time.Now()
+	breaker.failure()
+	if err := <-held; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("failed trial let the caller through: %v", err)
+	}
+
+	if err := waitWithin(breaker, time.Second); err != nil {
+		t.Fatalf("no trial after the second cooldown: %v", err)
+	}
+	if waited := time.Since(failedAt); waited < testCooldown {
+		t.Errorf("second trial %v after the failed one, want at least %v", waited, testCooldown)
+	}
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"realtimemap-temporal/shared"
+	"sync"
+	"time"
+)
+
+// DefaultDedupTTL is how long the ordering state of a vehicle that stopped reporting is kept.
+const DefaultDedupTTL = 10 * time.Minute
+
+// Deduplicator drops repeated and late positions before they're signalled to Temporal.
+type Deduplicator struct {
+	mu       sync.Mutex
+	policy   shared.OrderingPolicy
+	ttl      time.Duration
+	vehicles map[string]*dedupVehicle
+	sweptAt  time.Time
+}
+
+type dedupVehicle struct {
+	filter *shared.OrderingFilter
+	seenAt time.Time
+}
+
+// NewDeduplicator forgets vehicles that sent nothing for ttl, a zero ttl keeps them forever.
+func NewDeduplicator(policy shared.OrderingPolicy, ttl time.Duration) *Deduplicator {
+	return &Deduplicator{
+		policy:   policy,
+		ttl:      ttl,
+		vehicles: make(map[string]*dedupVehicle),
+		sweptAt:  time.Now(),
+	}
+}
+
+func (d *Deduplicator) Accept(position *shared.Position) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+
+	vehicle, ok := d.vehicles[position.VehicleId]
+	if !ok {
+		vehicle = &dedupVehicle{filter: shared.NewOrderingFilter(d.policy)}
+		d.vehicles[position.VehicleId] = vehicle
+	}
+	vehicle.seenAt = now
+
+	accepted, reason := vehicle.filter.Accept(position.Event, position.Timestamp)
+	if !accepted {
+		metrics.Add("dedup_"+reason, 1)
+	}
+	return accepted
+}
+
+// sweep forgets the silent vehicles, at most once per ttl.
+func (d *Deduplicator) sweep(now time.Time) {
+	if d.ttl <= 0 || now.Sub(d.sweptAt) < d.ttl {
+		return
+	}
+
+	for vehicleID, vehicle := range d.vehicles {
+		if now.Sub(vehicle.seenAt) >= d.ttl {
+			delete(d.vehicles, vehicleID)
+		}
+	}
+	d.sweptAt = now
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"realtimemap-temporal/shared"
+	"testing"
+	"time"
+)
+
+func TestDeduplicatorFiltersPerVehicle(t *testing.T) {
+	dedup := NewDeduplicator(shared.DefaultOrderingPolicy, DefaultDedupTTL)
+
+	steps := []struct {
+		vehicleID string
+		timestamp int64
+		accepted  bool
+	}{
+		{"0012.1234", 2000, true},
+		{"0012.1234", 2000, false},
+		{"0012.1234", 1000, false},
+		// another vehicle has its own filter
+		{"0012.5678", 1000, true},
+		{"0012.1234", 3000, true},
+	}
+
+	for i, step := range steps {
+		position := &shared.Position{VehicleId: step.vehicleID, Event: shared.VehicleEvent_VP, Timestamp: step.timestamp}
+		if accepted := dedup.Accept(position); accepted != step.accepted {
+			t.Errorf("step %v, %v at %v: got accepted %v, want %v", i, step.vehicleID, step.timestamp, accepted, step.accepted)
+		}
+	}
+}
+
+func TestDeduplicatorForgetsSilentVehicles(t *testing.T) {
+	dedup := NewDeduplicator(shared.DefaultOrderingPolicy, time.Minute)
+	dedup.Accept(&shared.Position{VehicleId: "0012.1234", Event: shared.VehicleEvent_VP, Timestamp: 2000})
+	dedup.Accept(&shared.Position{VehicleId: "0012.5678", Event: shared.VehicleEvent_VP, Timestamp: 2000})
+
+	// 0012.1234 went silent for the TTL, 0012.5678 kept reporting
+	now := time.Now()
+	dedup.vehicles["0012.1234"].seenAt = now.Add(-2 * time.Minute)
+	dedup.sweptAt = now.Add(-2 * time.Minute)
+	dedup.sweep(now)
+
+	if _, ok := dedup.vehicles["0012.1234"]; ok {
+		t.Error("silent vehicle wasn't forgotten")
+	}
+	if _, ok := dedup.vehicles["0012.5678"]; !ok {
+		t.Error("reporting vehicle was forgotten")
+	}
+
+	// a restarted vehicle starts over
+	if !dedup.Accept(&shared.Position{VehicleId: "0012.1234", Event: shared.VehicleEvent_VP, Timestamp: 1000}) {
+		t.Error("forgotten vehicle's position was dropped")
+	}
+}
+
+func TestDeduplicatorSweepsOncePerTTL(t *testing.T) {
+	dedup := NewDeduplicator(shared.DefaultOrderingPolicy, time.Minute)
+	dedup.Accept(&shared.Position{VehicleId: "0012.1234", Event: shared.VehicleEvent_VP, Timestamp: 2000})
+
+	now := time.Now()
+	dedup.vehicles["0012.1234"].seenAt = now.Add(-2 * time.Minute)
+	dedup.sweep(now)
+
+	if _, ok := dedup.vehicles["0012.1234"]; !ok {
+		t.Error("swept before the TTL passed since the last sweep")
+	}
+}
@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"log/slog"
+)
+
+// OverflowPolicy decides what happens to a task submitted while its queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes Submit wait for room, pushing back on the ingress source.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the task and counts it in the metrics.
+	OverflowDrop
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch strings.ToLower(s) {
+	case "block":
+		return OverflowBlock, nil
+	case "drop":
+		return OverflowDrop, nil
+	default:
+		return OverflowBlock, fmt.Errorf("unknown overflow policy %q", s)
+	}
+}
+
+type DispatcherConfig struct {
+	// Workers is the number of goroutines delivering tasks, tasks with the same key always
+	// go to the same worker so they are delivered in order.
+	Workers int
+	// QueueSize bounds the number of pending tasks per worker.
+	QueueSize int
+	Overflow  OverflowPolicy
+	// MaxRetries is the number of extra attempts made for a failing task.
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// BreakerThreshold consecutive failures open the circuit for BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+var DefaultDispatcherConfig = DispatcherConfig{
+	Workers:          16,
+	QueueSize:        256,
+	Overflow:         OverflowBlock,
+	MaxRetries:       5,
+	InitialBackoff:   100 * time.Millisecond,
+	MaxBackoff:       5 * time.Second,
+	BreakerThreshold: 20,
+	BreakerCooldown:  10 * time.Second,
+}
+
+// Task is a unit of work such as signalling a Vehicle workflow.
+type Task func(ctx context.Context) error
+
+// Dispatcher decouples ingress from Temporal with bounded, per key ordered queues.
+type Dispatcher struct {
+	config  DispatcherConfig
+	queues  []chan Task
+	breaker *circuitBreaker
+	// stopping is closed once the context given to Start is cancelled
+	stopping chan struct{}
+}
+
+func NewDispatcher(config DispatcherConfig) *Dispatcher {
+	if config.Workers <= 0 {
+		config.Workers = DefaultDispatcherConfig.Workers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultDispatcherConfig.QueueSize
+	}
+
+	queues := make([]chan Task, config.Workers)
+	for i := range queues {
+		queues[i] = make(chan Task, config.QueueSize)
+	}
+
+	return &Dispatcher{
+		config:   config,
+		queues:   queues,
+		breaker:  newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		stopping: make(chan struct{}),
+	}
+}
+
+// Start runs the workers until ctx is cancelled, tasks still queued at that point are discarded.
+// Tasks submitted before Start wait in their queue.
+func (d *Dispatcher) Start(ctx context.Context) <-chan bool {
+	done := make(chan bool)
+
+	go func() {
+		<-ctx.Done()
+		close(d.stopping)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for _, queue := range d.queues {
+		wg.Add(1)
+		go func(queue chan Task) {
+			defer wg.Done()
+			d.work(ctx, queue)
+		}(queue)
+	}
+
+	go func() {
+		wg.Wait()
+		done <- true
+	}()
+
+	return done
+}
+
+// Submit queues the task behind every other task with the same key. It returns false when
+// the task was dropped because the queue is full or the dispatcher is stopping.
+func (d *Dispatcher) Submit(key string, task Task) bool {
+	queue := d.queues[d.partition(key)]
+
+	if d.config.Overflow == OverflowDrop {
+		select {
+		case queue <- task:
+			return true
+		default:
+			metrics.Add(metricDropped, 1)
+			return false
+		}
+	}
+
+	select {
+	case queue <- task:
+		return true
+	case <-d.stopping:
+		return false
+	}
+}
+
+func (d *Dispatcher) partition(key string) int {
+	return int(hash(key) % uint32(len(d.queues)))
+}
+
+func (d *Dispatcher) work(ctx context.Context, queue chan Task) {
+	for {
+		select {
+		case task := <-queue:
+			if err := d.run(ctx, task); err != nil && ctx.Err() == nil {
+				metrics.Add(metricFailed, 1)
+				slog.Error("Dropping task after retries", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context, task Task) error {
+	backoff := d.config.InitialBackoff
+
+	for attempt := 0; ; attempt++ {
+		if err := d.breaker.wait(ctx); err != nil {
+			return err
+		}
+
+		err := task(ctx)
+		if err == nil {
+			d.breaker.success()
+			metrics.Add(metricDispatched, 1)
+			return nil
+		}
+		d.breaker.failure()
+
+		if attempt >= d.config.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		metrics.Add(metricRetried, 1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		backoff *= 2
+		if d.config.MaxBackoff > 0 && backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("temporal is down")
+
+func testDispatcherConfig() DispatcherConfig {
+	return DispatcherConfig{
+		Workers:        4,
+		QueueSize:      16,
+		Overflow:       OverflowBlock,
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+}
+
+// startDispatcher runs the dispatcher until the test ends.
+func startDispatcher(t *testing.T, dispatcher *Dispatcher) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := dispatcher.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestDispatcherRetriesUntilSuccess(t *testing.T) {
+	dispatcher := NewDispatcher(testDispatcherConfig())
+	startDispatcher(t, dispatcher)
+
+	attempts := 0
+	delivered := make(chan int)
+	dispatcher.Submit("0012.1234", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errTest
+		}
+		delivered <- attempts
+		return nil
+	})
+
+	select {
+	case n := <-delivered:
+		if n != 3 {
+			t.Errorf("delivered after %v attempts, want 3", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task wasn't retried")
+	}
+}
+
+func TestDispatcherGivesUpAfterMaxRetries(t *testing.T) {
+	config := testDispatcherConfig()
+	dispatcher := NewDispatcher(config)
+	startDispatcher(t, dispatcher)
+
+	attempts := atomic.Int32{}
+	dispatcher.Submit("0012.1234", func(ctx context.Context) error {
+		attempts.Add(1)
+		return errTest
+	})
+
+	// the next task of the same worker only runs once the failing one was dropped
+	next := make(chan struct{})
+	dispatcher.Submit("0012.1234", func(ctx context.Context) error {
+		close(next)
+		return nil
+	})
+
+	select {
+	case <-next:
+	case <-time.After(time.Second):
+		t.Fatal("failing task wasn't dropped")
+	}
+	if n := attempts.Load(); n != int32(config.MaxRetries+1) {
+		t.Errorf("made %v attempts, want %v", n, config.MaxRetries+1)
+	}
+}
+
+func TestDispatcherKeepsKeyOrder(t *testing.T) {
+	config := testDispatcherConfig()
+	config.QueueSize = 256
+	dispatcher := NewDispatcher(config)
+
+	mu := sync.Mutex{}
+	delivered := make(map[string][]int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		for _, vehicle := range []string{"0012.1234", "0012.5678", "0040.431"} {
+			vehicle, i := vehicle, i
+			wg.Add(1)
+			// failing first attempts must not let later tasks overtake
+			failed := false
+			dispatcher.Submit(vehicle, func(ctx context.Context) error {
+				if !failed && i%7 == 0 {
+					failed = true
+					return errTest
+				}
+				mu.Lock()
+				delivered[vehicle] = append(delivered[vehicle], i)
+				mu.Unlock()
+				wg.Done()
+				return nil
+			})
+		}
+	}
+	// tasks submitted before Start wait in their queue
+	startDispatcher(t, dispatcher)
+	wg.Wait()
+
+	for vehicle, order := range delivered {
+		for i, n := range order {
+			if n != i {
+				t.Fatalf("%v got tasks in order %v", vehicle, order)
+			}
+		}
+	}
+}
+
+func TestDispatcherDropsWhenFull(t *testing.T) {
+	config := testDispatcherConfig()
+	config.Workers, config.QueueSize, config.Overflow = 1, 2, OverflowDrop
+	dispatcher := NewDispatcher(config)
+
+	task := func(ctx context.Context) error { return nil }
+	for i := 0; i < config.QueueSize; i++ {
+		if !dispatcher.Submit(fmt.Sprint(i), task) {
+			t.Fatalf("dropped task %v of a queue with room", i)
+		}
+	}
+	if dispatcher.Submit("full", task) {
+		t.Error("full queue took the task")
+	}
+}
+
+func TestDispatcherSubmitReturnsWhenStopping(t *testing.T) {
+	config := testDispatcherConfig()
+	config.Workers, config.QueueSize = 1, 1
+	dispatcher := NewDispatcher(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := dispatcher.Start(ctx)
+
+	// the worker is stuck on the first task and the second fills the queue
+	release := make(chan struct{})
+	defer func() {
+		close(release)
+		<-done
+	}()
+	dispatcher.Submit("a", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	dispatcher.Submit("b", func(ctx context.Context) error { return nil })
+
+	submitted := make(chan bool)
+	go func() {
+		submitted <- dispatcher.Submit("c", func(ctx context.Context) error { return nil })
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("submit didn't block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case ok := <-submitted:
+		if ok {
+			t.Error("stopping dispatcher took the task")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submit still blocked after stopping")
+	}
+}
+
+func TestDispatcherHoldsBackWhileCircuitOpen(t *testing.T) {
+	config := testDispatcherConfig()
+	config.Workers, config.MaxRetries = 1, 0
+	config.BreakerThreshold, config.BreakerCooldown = 2, 100*time.Millisecond
+	dispatcher := NewDispatcher(config)
+	startDispatcher(t, dispatcher)
+
+	failing := func(ctx context.Context) error { return errTest }
+	dispatcher.Submit("a", failing)
+	dispatcher.Submit("a", failing)
+
+	submittedAt := time.Now()
+	ran := make(chan time.Duration)
+	dispatcher.Submit("a", func(ctx context.Context) error {
+		ran <- time.Since(submittedAt)
+		return nil
+	})
+
+	select {
+	case waited := <-ran:
+		if waited < config.BreakerCooldown/2 {
+			t.Errorf("task ran after %v while the circuit was open", waited)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task didn't run after the cooldown")
+	}
+}
@@ -0,0 +1,192 @@
+package pipeline
+
+import (
+	"fmt"
+	"realtimemap-temporal/shared"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	geo "github.com/kellydunn/golang-geo"
+)
+
+// DownsamplePolicy decides which positions of a vehicle are worth signalling. A position
+// passes when MinInterval elapsed or the vehicle moved MinDistanceMeters since the last
+// passed position, zero disables the criterion. Heading changes of at least
+// HeadingChangeDegrees and door state changes always pass. The zero policy passes everything.
+type DownsamplePolicy struct {
+	MinInterval          time.Duration
+	MinDistanceMeters    float64
+	HeadingChangeDegrees int32
+}
+
+func (p DownsamplePolicy) isZero() bool {
+	return p.MinInterval <= 0 && p.MinDistanceMeters <= 0
+}
+
+// ParseDownsamplePolicy parses a policy such as "interval=5s,distance=50,heading=30".
+func ParseDownsamplePolicy(s string) (DownsamplePolicy, error) {
+	policy := DownsamplePolicy{}
+
+	for _, field := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return policy, fmt.Errorf("invalid downsample field %q", field)
+		}
+
+		var err error
+		switch key {
+		case "interval":
+			policy.MinInterval, err = time.ParseDuration(value)
+		case "distance":
+			policy.MinDistanceMeters, err = strconv.ParseFloat(value, 64)
+		case "heading":
+			var heading int64
+			heading, err = strconv.ParseInt(value, 10, 32)
+			policy.HeadingChangeDegrees = int32(heading)
+		default:
+			err = fmt.Errorf("unknown downsample field %q", key)
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+
+	return policy, nil
+}
+
+// OrganizationPolicies implements flag.Value for per organization overrides written as
+// "<orgId>:<policy>", e.g. "0012:interval=5s,distance=50".
+type OrganizationPolicies map[string]DownsamplePolicy
+
+func (p OrganizationPolicies) String() string {
+	return fmt.Sprintf("%v", map[string]DownsamplePolicy(p))
+}
+
+func (p OrganizationPolicies) Set(s string) error {
+	orgID, spec, ok := strings.Cut(s, ":")
+	if !ok || orgID == "" {
+		return fmt.Errorf("invalid organization policy %q", s)
+	}
+
+	policy, err := ParseDownsamplePolicy(spec)
+	if err != nil {
+		return err
+	}
+	p[orgID] = policy
+	return nil
+}
+
+type Downsampler struct {
+	mu            sync.Mutex
+	defaultPolicy DownsamplePolicy
+	organizations OrganizationPolicies
+	ttl           time.Duration
+	vehicles      map[string]*downsampledVehicle
+	sweptAt       time.Time
+}
+
+// downsampledVehicle is what's compared of the last passed position of a vehicle.
+type downsampledVehicle struct {
+	timestamp int64
+	latitude  float64
+	longitude float64
+	heading   int32
+	doorsOpen bool
+	seenAt    time.Time
+}
+
+// NewDownsampler forgets vehicles that sent nothing for ttl, a zero ttl keeps them forever.
+func NewDownsampler(defaultPolicy DownsamplePolicy, organizations OrganizationPolicies, ttl time.Duration) *Downsampler {
+	return &Downsampler{
+		defaultPolicy: defaultPolicy,
+		organizations: organizations,
+		ttl:           ttl,
+		vehicles:      make(map[string]*downsampledVehicle),
+		sweptAt:       time.Now(),
+	}
+}
+
+func (d *Downsampler) Accept(position *shared.Position) bool {
+	policy, ok := d.organizations[position.OrgId]
+	if !ok {
+		policy = d.defaultPolicy
+	}
+	if policy.isZero() {
+		return true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+
+	last, ok := d.vehicles[position.VehicleId]
+	if !ok || passes(policy, last, position) {
+		d.vehicles[position.VehicleId] = &downsampledVehicle{
+			timestamp: position.Timestamp,
+			latitude:  position.Latitude,
+			longitude: position.Longitude,
+			heading:   position.Heading,
+			doorsOpen: position.DoorsOpen,
+			seenAt:    now,
+		}
+		return true
+	}
+
+	last.seenAt = now
+	metrics.Add("downsampled", 1)
+	return false
+}
+
+// sweep forgets the silent vehicles, at most once per ttl.
+func (d *Downsampler) sweep(now time.Time) {
+	if d.ttl <= 0 || now.Sub(d.sweptAt) < d.ttl {
+		return
+	}
+
+	for vehicleID, vehicle := range d.vehicles {
+		if now.Sub(vehicle.seenAt) >= d.ttl {
+			delete(d.vehicles, vehicleID)
+		}
+	}
+	d.sweptAt = now
+}
+
+func passes(policy DownsamplePolicy, last *downsampledVehicle, position *shared.Position) bool {
+	if position.DoorsOpen != last.doorsOpen {
+		return true
+	}
+
+	if policy.HeadingChangeDegrees > 0 && headingChange(last.heading, position.Heading) >= policy.HeadingChangeDegrees {
+		return true
+	}
+
+	if policy.MinInterval > 0 && time.Duration(position.Timestamp-last.timestamp)*time.Millisecond >= policy.MinInterval {
+		return true
+	}
+
+	if policy.MinDistanceMeters > 0 {
+		from := geo.NewPoint(last.latitude, last.longitude)
+		to := geo.NewPoint(position.Latitude, position.Longitude)
+		if from.GreatCircleDistance(to)*1000 >= policy.MinDistanceMeters {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headingChange is the smallest angle between two headings in degrees.
+func headingChange(from int32, to int32) int32 {
+	diff := (to - from) % 360
+	if diff < 0 {
+		diff += 360
+	}
+	if diff > 180 {
+		diff = 360 - diff
+	}
+	return diff
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"realtimemap-temporal/shared"
+	"testing"
+	"time"
+)
+
+func TestDownsamplerPassesPerPolicy(t *testing.T) {
+	downsampler := NewDownsampler(
+		DownsamplePolicy{MinInterval: 5 * time.Second, MinDistanceMeters: 50, HeadingChangeDegrees: 30},
+		OrganizationPolicies{"0040": {}},
+		DefaultDedupTTL,
+	)
+
+	steps := []struct {
+		name     string
+		position *shared.Position
+		passed   bool
+	}{
+		{"first position", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 0, Latitude: 60.17, Longitude: 24.94}, true},
+		{"standing still", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 1000, Latitude: 60.17, Longitude: 24.94}, false},
+		{"interval elapsed", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 5000, Latitude: 60.17, Longitude: 24.94}, true},
+		{"moved far", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 6000, Latitude: 60.171, Longitude: 24.94}, true},
+		{"turned", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 7000, Latitude: 60.171, Longitude: 24.94, Heading: 45}, true},
+		{"doors opened", &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 8000, Latitude: 60.171, Longitude: 24.94, Heading: 45, DoorsOpen: true}, true},
+		{"another vehicle", &shared.Position{VehicleId: "0012.5678", OrgId: "0012", Timestamp: 8000}, true},
+		{"overridden organization", &shared.Position{VehicleId: "0040.431", OrgId: "0040", Timestamp: 0}, true},
+		{"overridden organization again", &shared.Position{VehicleId: "0040.431", OrgId: "0040", Timestamp: 0}, true},
+	}
+
+	for _, step := range steps {
+		if passed := downsampler.Accept(step.position); passed != step.passed {
+			t.Errorf("%v: got passed %v, want %v", step.name, passed, step.passed)
+		}
+	}
+}
+
+func TestDownsamplerForgetsSilentVehicles(t *testing.T) {
+	downsampler := NewDownsampler(DownsamplePolicy{MinInterval: time.Hour}, nil, time.Minute)
+	downsampler.Accept(&shared.Position{VehicleId: "0012.1234", Timestamp: 2000})
+	downsampler.Accept(&shared.Position{VehicleId: "0012.5678", Timestamp: 2000})
+
+	// 0012.1234 went silent for the TTL, 0012.5678 kept reporting
+	now := time.Now()
+	downsampler.vehicles["0012.1234"].seenAt = now.Add(-2 * time.Minute)
+	downsampler.sweptAt = now.Add(-2 * time.Minute)
+	downsampler.sweep(now)
+
+	if _, ok := downsampler.vehicles["0012.1234"]; ok {
+		t.Error("silent vehicle wasn't forgotten")
+	}
+	if _, ok := downsampler.vehicles["0012.5678"]; !ok {
+		t.Error("reporting vehicle was forgotten")
+	}
+
+	// a returning vehicle's first position passes
+	if !downsampler.Accept(&shared.Position{VehicleId: "0012.1234", Timestamp: 3000}) {
+		t.Error("forgotten vehicle's position was dropped")
+	}
+}
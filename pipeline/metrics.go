@@ -0,0 +1,14 @@
+package pipeline
+
+import "expvar"
+
+// Metrics are published through expvar under the "pipeline" key.
+var metrics = expvar.NewMap("pipeline")
+
+const (
+	metricDispatched  = "dispatched"
+	metricDropped     = "dropped_queue_full"
+	metricRetried     = "retried"
+	metricFailed      = "failed"
+	metricCircuitOpen = "circuit_opened"
+)
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+const virtualNodesPerInstance = 128
+
+// Partitioner splits vehicles over ingest instances with a consistent hash ring, so every
+// vehicle is signalled by exactly one instance and only a fraction of the vehicles move
+// when instances are added or removed.
+type Partitioner struct {
+	instance int
+	hashes   []uint32
+	owners   map[uint32]int
+}
+
+// NewPartitioner creates the partitioner of instance out of instances, counted from 0.
+func NewPartitioner(instance int, instances int) (*Partitioner, error) {
+	if instances <= 0 || instance < 0 || instance >= instances {
+		return nil, fmt.Errorf("instance %v out of %v is invalid", instance, instances)
+	}
+
+	p := &Partitioner{
+		instance: instance,
+		hashes:   make([]uint32, 0, instances*virtualNodesPerInstance),
+		owners:   make(map[uint32]int, instances*virtualNodesPerInstance),
+	}
+	for i := 0; i < instances; i++ {
+		for v := 0; v < virtualNodesPerInstance; v++ {
+			h := hash(fmt.Sprintf("instance-%v-%v", i, v))
+			if _, taken := p.owners[h]; taken {
+				continue
+			}
+			p.owners[h] = i
+			p.hashes = append(p.hashes, h)
+		}
+	}
+	sort.Slice(p.hashes, func(i, j int) bool { return p.hashes[i] < p.hashes[j] })
+
+	return p, nil
+}
+
+// Owns reports whether this instance is responsible for the vehicle.
+func (p *Partitioner) Owns(vehicleID string) bool {
+	h := hash(vehicleID)
+	i := sort.Search(len(p.hashes), func(i int) bool { return p.hashes[i] >= h })
+	if i == len(p.hashes) {
+		i = 0
+	}
+	return p.owners[p.hashes[i]] == p.instance
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"fmt"
+	"realtimemap-temporal/shared"
+	"sync"
+	"time"
+
+	geo "github.com/kellydunn/golang-geo"
+)
+
+const (
+	RejectReason_NULL_ISLAND          = "null_island"
+	RejectReason_OUTSIDE_SERVICE_AREA = "outside_service_area"
+	RejectReason_SPEED                = "speed"
+	RejectReason_TELEPORT             = "teleport"
+	RejectReason_LOCATION_SOURCE      = "location_source"
+	RejectReason_ACCURACY             = "accuracy"
+)
+
+// maxConsecutiveTeleports rejected jumps in a row make the validator trust the new location,
+// otherwise a single bad anchor fix would get every later fix of the vehicle rejected.
+const maxConsecutiveTeleports = 3
+
+// RejectionError explains why a position was rejected.
+type RejectionError struct {
+	Reason string
+	Detail string
+}
+
+func (e *RejectionError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Reason, e.Detail)
+}
+
+type ValidatorConfig struct {
+	// ServiceArea rejects positions outside of it, nil disables the check.
+	ServiceArea *shared.BoundingBox
+	// MaxSpeedMetersPerSecond caps both the reported speed and the speed implied by two
+	// consecutive fixes, zero disables the check.
+	MaxSpeedMetersPerSecond float64
+	// AllowedLocationSources lists the HFP loc values trusted to be accurate, e.g. GPS,
+	// empty allows every source. HFP reports no accuracy, the source is the best proxy.
+	AllowedLocationSources []string
+	// MaxAccuracyMeters rejects fixes whose reported accuracy radius is larger, fixes without
+	// one pass. Zero disables the check.
+	MaxAccuracyMeters float64
+}
+
+// Validator rejects implausible positions before they reach the trail and the geofences.
+type Validator struct {
+	mu       sync.Mutex
+	config   ValidatorConfig
+	sources  map[string]struct{}
+	ttl      time.Duration
+	vehicles map[string]*validatedVehicle
+	sweptAt  time.Time
+}
+
+// validatedVehicle is the newest valid fix of a vehicle and the jumps rejected since.
+type validatedVehicle struct {
+	timestamp int64
+	latitude  float64
+	longitude float64
+	teleports int
+	seenAt    time.Time
+}
+
+// NewValidator forgets vehicles that sent nothing for ttl, a zero ttl keeps them forever.
+func NewValidator(config ValidatorConfig, ttl time.Duration) *Validator {
+	sources := make(map[string]struct{}, len(config.AllowedLocationSources))
+	for _, source := range config.AllowedLocationSources {
+		sources[source] = struct{}{}
+	}
+
+	return &Validator{
+		config:   config,
+		sources:  sources,
+		ttl:      ttl,
+		vehicles: make(map[string]*validatedVehicle),
+		sweptAt:  time.Now(),
+	}
+}
+
+// Validate returns a *RejectionError when the position is implausible, rejections are counted.
+func (v *Validator) Validate(position *shared.Position) error {
+	err := v.validate(position)
+	if err != nil {
+		metrics.Add("rejected_"+err.Reason, 1)
+		return err
+	}
+	return nil
+}
+
+func (v *Validator) validate(position *shared.Position) *RejectionError {
+	if position.Latitude == 0 && position.Longitude == 0 {
+		return &RejectionError{Reason: RejectReason_NULL_ISLAND, Detail: "position is 0,0"}
+	}
+
+	if v.config.ServiceArea != nil && !v.config.ServiceArea.Contains(position.Latitude, position.Longitude) {
+		return &RejectionError{
+			Reason: RejectReason_OUTSIDE_SERVICE_AREA,
+			Detail: fmt.Sprintf("%v,%v is outside of the service area", position.Latitude, position.Longitude),
+		}
+	}
+
+	if len(v.sources) > 0 {
+		if _, ok := v.sources[position.LocationSource]; !ok {
+			return &RejectionError{
+				Reason: RejectReason_LOCATION_SOURCE,
+				Detail: fmt.Sprintf("location source %q is not trusted", position.LocationSource),
+			}
+		}
+	}
+
+	if maxAccuracy := v.config.MaxAccuracyMeters; maxAccuracy > 0 && position.AccuracyMeters > maxAccuracy {
+		return &RejectionError{
+			Reason: RejectReason_ACCURACY,
+			Detail: fmt.Sprintf("accuracy of %v m exceeds %v m", position.AccuracyMeters, maxAccuracy),
+		}
+	}
+
+	maxSpeed := v.config.MaxSpeedMetersPerSecond
+	if maxSpeed > 0 && position.Speed > maxSpeed {
+		return &RejectionError{
+			Reason: RejectReason_SPEED,
+			Detail: fmt.Sprintf("reported speed %v m/s exceeds %v m/s", position.Speed, maxSpeed),
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	v.sweep(now)
+
+	last, ok := v.vehicles[position.VehicleId]
+	if !ok {
+		v.vehicles[position.VehicleId] = &validatedVehicle{
+			timestamp: position.Timestamp,
+			latitude:  position.Latitude,
+			longitude: position.Longitude,
+			seenAt:    now,
+		}
+		return nil
+	}
+
+	last.seenAt = now
+	// a late fix can't be checked against the newer one and doesn't replace it
+	if position.Timestamp <= last.timestamp {
+		return nil
+	}
+
+	if maxSpeed > 0 {
+		from := geo.NewPoint(last.latitude, last.longitude)
+		to := geo.NewPoint(position.Latitude, position.Longitude)
+		meters := from.GreatCircleDistance(to) * 1000
+		seconds := float64(position.Timestamp-last.timestamp) / 1000
+
+		if meters/seconds > maxSpeed && last.teleports < maxConsecutiveTeleports {
+			last.teleports++
+			return &RejectionError{
+				Reason: RejectReason_TELEPORT,
+				Detail: fmt.Sprintf("moved %.0f m in %.1f s", meters, seconds),
+			}
+		}
+	}
+
+	last.timestamp = position.Timestamp
+	last.latitude = position.Latitude
+	last.longitude = position.Longitude
+	last.teleports = 0
+	return nil
+}
+
+// sweep forgets the silent vehicles, at most once per ttl.
+func (v *Validator) sweep(now time.Time) {
+	if v.ttl <= 0 || now.Sub(v.sweptAt) < v.ttl {
+		return
+	}
+
+	for vehicleID, vehicle := range v.vehicles {
+		if now.Sub(vehicle.seenAt) >= v.ttl {
+			delete(v.vehicles, vehicleID)
+		}
+	}
+	v.sweptAt = now
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"errors"
+	"realtimemap-temporal/shared"
+	"testing"
+	"time"
+)
+
+func TestValidatorRejects(t *testing.T) {
+	validator := NewValidator(ValidatorConfig{
+		ServiceArea:             &shared.BoundingBox{MinLatitude: 59.9, MinLongitude: 24.3, MaxLatitude: 60.5, MaxLongitude: 25.5},
+		MaxSpeedMetersPerSecond: 40,
+		AllowedLocationSources:  []string{"GPS"},
+		MaxAccuracyMeters:       50,
+	}, DefaultDedupTTL)
+
+	tests := []struct {
+		name     string
+		position *shared.Position
+		reason   string
+	}{
+		{"null island", &shared.Position{LocationSource: "GPS"}, RejectReason_NULL_ISLAND},
+		{"outside service area", &shared.Position{Latitude: 61.5, Longitude: 23.8, LocationSource: "GPS"}, RejectReason_OUTSIDE_SERVICE_AREA},
+		{"untrusted source", &shared.Position{Latitude: 60.17, Longitude: 24.94, LocationSource: "ODO"}, RejectReason_LOCATION_SOURCE},
+		{"inaccurate", &shared.Position{Latitude: 60.17, Longitude: 24.94, LocationSource: "GPS", AccuracyMeters: 80}, RejectReason_ACCURACY},
+		{"too fast", &shared.Position{Latitude: 60.17, Longitude: 24.94, LocationSource: "GPS", Speed: 50}, RejectReason_SPEED},
+		{"valid", &shared.Position{Latitude: 60.17, Longitude: 24.94, LocationSource: "GPS", AccuracyMeters: 10, Speed: 10}, ""},
+	}
+
+	for _, test := range tests {
+		err := validator.Validate(test.position)
+		rejection := &RejectionError{}
+		switch {
+		case test.reason == "" && err != nil:
+			t.Errorf("%v: rejected with %v", test.name, err)
+		case test.reason != "" && (!errors.As(err, &rejection) || rejection.Reason != test.reason):
+			t.Errorf("%v: got %v, want rejection for %v", test.name, err, test.reason)
+		}
+	}
+}
+
+func TestValidatorTeleports(t *testing.T) {
+	validator := NewValidator(ValidatorConfig{MaxSpeedMetersPerSecond: 40}, DefaultDedupTTL)
+
+	// 0.01° of latitude is about 1.1 km
+	steps := []struct {
+		name      string
+		timestamp int64
+		latitude  float64
+		rejected  bool
+	}{
+		{"anchor", 10_000, 60.17, false},
+		{"plausible", 20_000, 60.171, false},
+		{"jump", 21_000, 60.2, true},
+		// a late fix passes, but the jump is still measured from the newest valid fix
+		{"late", 15_000, 60.1705, false},
+		{"jump again", 22_000, 60.2, true},
+		{"jump a third time", 23_000, 60.2, true},
+		// after maxConsecutiveTeleports rejections the new location is trusted
+		{"trusted", 24_000, 60.2, false},
+		{"near the new location", 25_000, 60.2001, false},
+	}
+
+	for _, step := range steps {
+		err := validator.Validate(&shared.Position{VehicleId: "0012.1234", Timestamp: step.timestamp, Latitude: step.latitude, Longitude: 24.94})
+		if rejected := err != nil; rejected != step.rejected {
+			t.Errorf("%v: got %v, want rejected %v", step.name, err, step.rejected)
+		}
+	}
+}
+
+func TestValidatorForgetsSilentVehicles(t *testing.T) {
+	validator := NewValidator(ValidatorConfig{MaxSpeedMetersPerSecond: 40}, time.Minute)
+	validator.Validate(&shared.Position{VehicleId: "0012.1234", Timestamp: 10_000, Latitude: 60.17, Longitude: 24.94})
+	validator.Validate(&shared.Position{VehicleId: "0012.5678", Timestamp: 10_000, Latitude: 60.17, Longitude: 24.94})
+
+	// 0012.1234 went silent for the TTL, 0012.5678 kept reporting
+	now := time.Now()
+	validator.vehicles["0012.1234"].seenAt = now.Add(-2 * time.Minute)
+	validator.sweptAt = now.Add(-2 * time.Minute)
+	validator.sweep(now)
+
+	if _, ok := validator.vehicles["0012.1234"]; ok {
+		t.Error("silent vehicle wasn't forgotten")
+	}
+	if _, ok := validator.vehicles["0012.5678"]; !ok {
+		t.Error("reporting vehicle was forgotten")
+	}
+
+	// a returning vehicle isn't measured against where it was before
+	if err := validator.Validate(&shared.Position{VehicleId: "0012.1234", Timestamp: 11_000, Latitude: 60.3, Longitude: 24.94}); err != nil {
+		t.Errorf("forgotten vehicle's position was rejected: %v", err)
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"realtimemap-temporal/data"
 	"realtimemap-temporal/shared"
 	"realtimemap-temporal/workflow"
+	"slices"
 	"sort"
 
 	"github.com/gin-gonic/gin"
@@ -18,9 +18,22 @@ import (
 
 func serveAPI(router *gin.Engine, redisCli *redis.Client, temporalClient client.Client) {
 	router.GET("/api/v1/organization", func(c *gin.Context) {
-		result := make([]*shared.Organization, 0, len(data.AllOrganizations))
+		orgIDs, err := workflow.ListOrganizations(c.Request.Context(), temporalClient)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
+			return
+		}
+
+		result := make([]*shared.Organization, 0, len(orgIDs))
+
+		// geofences are assigned at runtime, the organization workflows know the current ones
+		for _, orgID := range orgIDs {
+			org, err := workflow.GetOrganization(c.Request.Context(), temporalClient, orgID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
+				return
+			}
 
-		for _, org := range data.AllOrganizations {
 			if len(org.Geofences) > 0 {
 				result = append(result, &shared.Organization{
 					Id:   org.Id,
@@ -38,39 +51,32 @@ func serveAPI(router *gin.Engine, redisCli *redis.Client, temporalClient client.
 
 	router.GET("/api/v1/organization/:id", func(c *gin.Context) {
 		orgID := c.Param("id")
-		org, ok := data.AllOrganizations[orgID]
-		if !ok {
+		orgIDs, err := workflow.ListOrganizations(c.Request.Context(), temporalClient)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
+			return
+		}
+		// deleted organizations can still be queried until their history is gone
+		if !slices.Contains(orgIDs, orgID) {
 			c.JSON(http.StatusNotFound, map[string]any{"message": fmt.Sprintf("Organization %v not found", orgID)})
 			return
 		}
 
-		geofenceSet := make(map[string]struct{})
-		for _, geofence := range org.Geofences {
-			geofenceSet[geofence.Name] = struct{}{}
+		org, err := workflow.GetOrganization(c.Request.Context(), temporalClient, orgID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
+			return
 		}
 
-		geofences := make([]*shared.Geofence, 0)
-		for geofence := range geofenceSet {
-			resp, err := temporalClient.QueryWorkflow(
-				c.Request.Context(),                      // context
-				workflow.GetGeofenceWorkflowID(geofence), // workflow id
-				"",                                       // run id
-				shared.GeofencesQuery,                    // query type
-				&workflow.GetGeofenceRequest{},           // query input
-			)
+		geofences := make([]*shared.Geofence, 0, len(org.Geofences))
+		for _, definition := range org.Geofences {
+			geofence, err := workflow.GetGeofence(c.Request.Context(), temporalClient, definition.Name)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, map[string]any{"error": err})
 				return
 			}
 
-			geofenceResp := &workflow.GetGeofenceResponse{}
-			err = resp.Get(geofenceResp)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, map[string]error{"error": err})
-				return
-			}
-
-			geofences = append(geofences, geofenceResp.Geofence)
+			geofences = append(geofences, geofence)
 		}
 
 		sort.Slice(geofences, func(i, j int) bool {
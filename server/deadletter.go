@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"realtimemap-temporal/deadletter"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultDeadLetterLimit = 50
+
+// serveDeadLetters serves the dead letters of deadLetters, nil when this instance has none to
+// serve because they're kept in the memory of the ingest instances.
+func serveDeadLetters(router *gin.Engine, deadLetters deadletter.Reader) {
+	router.GET("/api/v1/deadletter", func(c *gin.Context) {
+		if deadLetters == nil {
+			c.JSON(http.StatusNotFound, map[string]any{"message": "dead letters are kept by the ingest instances, run them with -deadletter redis to share them"})
+			return
+		}
+
+		limit := defaultDeadLetterLimit
+		if value := c.Query("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				c.JSON(http.StatusBadRequest, map[string]any{"message": "limit must be a positive number"})
+				return
+			}
+			limit = parsed
+		}
+
+		counts, err := deadLetters.Counts(c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]any{"message": err.Error()})
+			return
+		}
+		records, err := deadLetters.Recent(c, c.Query("reason"), limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, map[string]any{"message": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, map[string]any{
+			"counts":  counts,
+			"records": records,
+		})
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"realtimemap-temporal/deadletter"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// get serves a GET of path by router, the body is decoded into response.
+func get(t *testing.T, router *gin.Engine, path string, response any) int {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("%v: %v", path, err)
+	}
+	return recorder.Code
+}
+
+type failingReader struct{}
+
+func (failingReader) Recent(ctx context.Context, reason string, limit int) ([]*deadletter.Record, error) {
+	return nil, errors.New("redis is down")
+}
+
+func (failingReader) Counts(ctx context.Context) (map[string]int64, error) {
+	return nil, errors.New("redis is down")
+}
+
+func TestServeDeadLetters(t *testing.T) {
+	store := deadletter.NewStore(nil, 0)
+	for _, reason := range []string{deadletter.Reason_MALFORMED, deadletter.Reason_MISSING_FIELDS, deadletter.Reason_MALFORMED} {
+		store.Add(&deadletter.Record{Reason: reason})
+	}
+	router := gin.New()
+	serveDeadLetters(router, store)
+
+	tests := []struct {
+		path    string
+		status  int
+		records int
+	}{
+		{"/api/v1/deadletter", http.StatusOK, 3},
+		{"/api/v1/deadletter?limit=2", http.StatusOK, 2},
+		{"/api/v1/deadletter?reason=malformed", http.StatusOK, 2},
+		{"/api/v1/deadletter?limit=0", http.StatusBadRequest, 0},
+		{"/api/v1/deadletter?limit=many", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		response := struct {
+			Counts  map[string]int64     `json:"counts"`
+			Records []*deadletter.Record `json:"records"`
+		}{}
+		if status := get(t, router, test.path, &response); status != test.status || len(response.Records) != test.records {
+			t.Errorf("%v: got %v with %v records, want %v with %v", test.path, status, len(response.Records), test.status, test.records)
+		}
+		if test.status == http.StatusOK && response.Counts[deadletter.Reason_MALFORMED] != 2 {
+			t.Errorf("%v: got counts %v", test.path, response.Counts)
+		}
+	}
+}
+
+func TestServeDeadLettersWithoutReader(t *testing.T) {
+	router := gin.New()
+	serveDeadLetters(router, nil)
+
+	response := map[string]any{}
+	if status := get(t, router, "/api/v1/deadletter", &response); status != http.StatusNotFound {
+		t.Errorf("got %v, want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestServeDeadLettersReaderError(t *testing.T) {
+	router := gin.New()
+	serveDeadLetters(router, failingReader{})
+
+	response := map[string]any{}
+	if status := get(t, router, "/api/v1/deadletter", &response); status != http.StatusInternalServerError {
+		t.Errorf("got %v, want %v", status, http.StatusInternalServerError)
+	}
+}
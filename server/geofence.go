@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"realtimemap-temporal/shared"
+	"realtimemap-temporal/workflow"
+
+	"github.com/gin-gonic/gin"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+type geofenceRequest struct {
+	Geometry *shared.Geometry `json:"geometry"`
+}
+
+func serveGeofences(router *gin.Engine, temporalClient client.Client, settings workflow.GeofenceSettings) {
+	router.GET("/api/v1/geofence", func(c *gin.Context) {
+		names, err := workflow.ListGeofences(c.Request.Context(), temporalClient)
+		if err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		geofences := make([]*shared.Geofence, 0, len(names))
+		for _, name := range names {
+			geofence, err := workflow.GetGeofence(c.Request.Context(), temporalClient, name)
+			if err != nil {
+				writeTemporalError(c, err)
+				return
+			}
+			geofences = append(geofences, geofence)
+		}
+
+		c.JSON(http.StatusOK, geofences)
+	})
+
+	router.GET("/api/v1/geofence/:name", func(c *gin.Context) {
+		geofence, err := workflow.GetGeofence(c.Request.Context(), temporalClient, c.Param("name"))
+		if err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, geofence)
+	})
+
+	router.POST("/api/v1/geofence", func(c *gin.Context) {
+		definition := &shared.GeofenceDefinition{}
+		if err := c.ShouldBindJSON(definition); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]any{"message": err.Error()})
+			return
+		}
+		if _, err := definition.Shape(); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]any{"message": err.Error()})
+			return
+		}
+
+		if err := workflow.CreateGeofence(c.Request.Context(), temporalClient, settings, definition); err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, definition)
+	})
+
+	router.PUT("/api/v1/geofence/:name", func(c *gin.Context) {
+		request := &geofenceRequest{}
+		if err := c.ShouldBindJSON(request); err != nil {
+			c.JSON(http.StatusBadRequest, map[string]any{"message": err.Error()})
+			return
+		}
+
+		// the geofence hands the new definition to its organizations
+		definition := &shared.GeofenceDefinition{
+			Name:     c.Param("name"),
+			Geometry: request.Geometry,
+		}
+		if err := workflow.UpdateGeofence(c.Request.Context(), temporalClient, definition); err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, definition)
+	})
+
+	router.DELETE("/api/v1/geofence/:name", func(c *gin.Context) {
+		name := c.Param("name")
+
+		// the geofence refuses to be deleted while organizations are assigned, so a failure half
+		// way leaves it running and the request can be retried
+		geofence, err := workflow.GetGeofence(c.Request.Context(), temporalClient, name)
+		if err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+		for _, orgID := range geofence.Organizations {
+			err := workflow.UnassignGeofence(c.Request.Context(), temporalClient, orgID, name)
+			if err != nil && !workflow.IsValidationError(err) {
+				writeTemporalError(c, err)
+				return
+			}
+		}
+
+		if err := workflow.DeleteGeofence(c.Request.Context(), temporalClient, name); err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	router.PUT("/api/v1/organization/:id/geofence/:name", func(c *gin.Context) {
+		// updating a missing organization or geofence fails with not found
+		orgID, name := c.Param("id"), c.Param("name")
+
+		if err := workflow.AssignGeofence(c.Request.Context(), temporalClient, orgID, name); err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+
+	router.DELETE("/api/v1/organization/:id/geofence/:name", func(c *gin.Context) {
+		orgID, name := c.Param("id"), c.Param("name")
+
+		if err := workflow.UnassignGeofence(c.Request.Context(), temporalClient, orgID, name); err != nil {
+			writeTemporalError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// writeTemporalError maps missing workflows to 404, existing ones to 409 and updates rejected
+// by their validator to 400.
+func writeTemporalError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+
+	var notFound *serviceerror.NotFound
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	switch {
+	case errors.As(err, &notFound):
+		status = http.StatusNotFound
+	case errors.As(err, &alreadyStarted):
+		status = http.StatusConflict
+	case workflow.IsValidationError(err):
+		status = http.StatusBadRequest
+	}
+
+	c.JSON(status, map[string]any{"error": err.Error()})
+}
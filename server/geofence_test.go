@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"realtimemap-temporal/workflow"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/temporal"
+)
+
+func TestWriteTemporalError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"not found", serviceerror.NewNotFound("workflow not found"), http.StatusNotFound},
+		{"wrapped not found", fmt.Errorf("geofence 0012.1: %w", serviceerror.NewNotFound("workflow not found")), http.StatusNotFound},
+		{"already started", serviceerror.NewWorkflowExecutionAlreadyStarted("already started", "", ""), http.StatusConflict},
+		{"rejected update", temporal.NewApplicationError("radius must be positive", workflow.ValidationErrorType), http.StatusBadRequest},
+		{"failed update", temporal.NewApplicationError("boom", "PanicError"), http.StatusInternalServerError},
+		{"unavailable", errors.New("connection refused"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		writeTemporalError(c, test.err)
+
+		if recorder.Code != test.status {
+			t.Errorf("%v: got %v, want %v", test.name, recorder.Code, test.status)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+	"realtimemap-temporal/ingress"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveHealth(router *gin.Engine, health ingress.HealthReporter) {
+	router.GET("/health", func(c *gin.Context) {
+		// sources without a broker connection, e.g. files, are always healthy
+		if health == nil {
+			c.JSON(http.StatusOK, map[string]any{"state": ingress.ConnectionState_CONNECTED})
+			return
+		}
+
+		status := health.Health()
+		if status.State != ingress.ConnectionState_CONNECTED && status.State != ingress.ConnectionState_UNKNOWN {
+			c.JSON(http.StatusServiceUnavailable, status)
+			return
+		}
+
+		c.JSON(http.StatusOK, status)
+	})
+}
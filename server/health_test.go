@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"realtimemap-temporal/ingress"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fixedHealth ingress.Health
+
+func (h fixedHealth) Health() ingress.Health {
+	return ingress.Health(h)
+}
+
+func TestServeHealth(t *testing.T) {
+	tests := []struct {
+		name   string
+		health ingress.HealthReporter
+		state  ingress.ConnectionState
+		status int
+	}{
+		{"no broker", nil, ingress.ConnectionState_CONNECTED, http.StatusOK},
+		{"connected", fixedHealth{State: ingress.ConnectionState_CONNECTED}, ingress.ConnectionState_CONNECTED, http.StatusOK},
+		{"not ingesting", ingress.UnknownHealth, ingress.ConnectionState_UNKNOWN, http.StatusOK},
+		{"reconnecting", fixedHealth{State: ingress.ConnectionState_RECONNECTING, Reconnects: 2}, ingress.ConnectionState_RECONNECTING, http.StatusServiceUnavailable},
+		{"down", fixedHealth{State: ingress.ConnectionState_DOWN}, ingress.ConnectionState_DOWN, http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		router := gin.New()
+		serveHealth(router, test.health)
+
+		response := ingress.Health{}
+		if status := get(t, router, "/health", &response); status != test.status || response.State != test.state {
+			t.Errorf("%v: got %v %v, want %v %v", test.name, status, response.State, test.status, test.state)
+		}
+	}
+}
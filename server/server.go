@@ -2,8 +2,12 @@ package server
 
 import (
 	"context"
+	"expvar"
 	"log/slog"
 	"net/http"
+	"realtimemap-temporal/deadletter"
+	"realtimemap-temporal/ingress"
+	"realtimemap-temporal/workflow"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,13 +26,32 @@ type Server struct {
 	srv *http.Server
 }
 
-func NewHttpServer(ctx context.Context, redisCli *redis.Client, temporalClient client.Client) *Server {
+func NewHttpServer(ctx context.Context, addr string, redisCli *redis.Client, temporalClient client.Client, health ingress.HealthReporter, deadLetters deadletter.Reader, geofenceSettings workflow.GeofenceSettings) *Server {
 	router := gin.Default()
 
 	serveAPI(router, redisCli, temporalClient)
+	serveGeofences(router, temporalClient, geofenceSettings)
+	serveHealth(router, health)
+	serveDeadLetters(router, deadLetters)
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
 
+	return newServer(ctx, addr, router)
+}
+
+// NewIngestServer serves only the health, dead letters and pipeline metrics of an ingest instance.
+func NewIngestServer(ctx context.Context, addr string, health ingress.HealthReporter, deadLetters deadletter.Reader) *Server {
+	router := gin.Default()
+
+	serveHealth(router, health)
+	serveDeadLetters(router, deadLetters)
+	router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
+	return newServer(ctx, addr, router)
+}
+
+func newServer(ctx context.Context, addr string, router *gin.Engine) *Server {
 	srv := &http.Server{
-		Addr:    ":12345",
+		Addr:    addr,
 		Handler: router,
 	}
 
@@ -1,21 +1,63 @@
 package shared
 
-import geo "github.com/kellydunn/golang-geo"
+import (
+	"encoding/json"
+	"fmt"
+
+	geo "github.com/kellydunn/golang-geo"
+)
 
 type Position struct {
-	VehicleId string  `json:"vehicleId"`
-	OrgId     string  `json:"orgId"`
-	OrgName   string  `json:"orgName"`
+	VehicleId     string `json:"vehicleId"`
+	OrgId         string `json:"orgId"`
+	OrgName       string `json:"orgName"`
+	TransportMode string `json:"transportMode"`
+	RouteId       string `json:"routeId,omitempty"`
+	DirectionId   string `json:"directionId,omitempty"`
+	Headsign      string `json:"headsign,omitempty"`
+	StartTime     string `json:"startTime,omitempty"`
+	NextStop      string `json:"nextStop,omitempty"`
+	Line          string `json:"line,omitempty"`
+	StopId        string `json:"stopId,omitempty"`
+	OperatingDay  string `json:"operatingDay,omitempty"`
+	// Event is the HFP event type the position was reported with, such as VP or DOO.
+	Event     string  `json:"event,omitempty"`
 	Timestamp int64   `json:"timestamp"`
 	Longitude float64 `json:"longitude"`
 	Latitude  float64 `json:"latitude"`
 	Heading   int32   `json:"heading"`
 	DoorsOpen bool    `json:"doorsOpen"`
 	Speed     float64 `json:"speed"`
+	// Delay is the schedule deviation in seconds, positive when the vehicle is ahead of schedule.
+	Delay          int32   `json:"delay"`
+	Occupancy      int32   `json:"occupancy"`
+	Odometer       int64   `json:"odometer"`
+	LocationSource string  `json:"locationSource,omitempty"`
+	Acceleration   float64 `json:"acceleration"`
+	// AccuracyMeters is the horizontal accuracy radius of the fix, 0 when the feed has none.
+	AccuracyMeters float64 `json:"accuracyMeters,omitempty"`
 }
 
 type PositionBatch struct {
-	Positions []*Position `json:"positions"`
+	Positions []*Position     `json:"positions"`
+	Events    []*VehicleEvent `json:"events"`
+	DoorsOpen bool            `json:"doorsOpen"`
+}
+
+// VehicleEvent is a stop, traffic light or journey assignment event reported by a vehicle.
+type VehicleEvent struct {
+	Type          string  `json:"type"`
+	VehicleId     string  `json:"vehicleId"`
+	OrgId         string  `json:"orgId"`
+	OrgName       string  `json:"orgName"`
+	TransportMode string  `json:"transportMode"`
+	RouteId       string  `json:"routeId,omitempty"`
+	DirectionId   string  `json:"directionId,omitempty"`
+	Line          string  `json:"line,omitempty"`
+	StopId        string  `json:"stopId,omitempty"`
+	Timestamp     int64   `json:"timestamp"`
+	Longitude     float64 `json:"longitude"`
+	Latitude      float64 `json:"latitude"`
 }
 
 type Organization struct {
@@ -30,28 +72,83 @@ type OrganizationDetails struct {
 }
 
 type Geofence struct {
-	Name           string   `json:"name"`
-	Longitude      float64  `json:"longitude"`
-	Latitude       float64  `json:"latitude"`
-	RadiusInMeters float64  `json:"radiusInMeters"`
-	VehiclesInZone []string `json:"vehiclesInZone"`
+	Name string `json:"name"`
+	// Longitude and Latitude are the centre of circles and of the bounding box of other shapes.
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	// RadiusInMeters is zero for shapes other than circles.
+	RadiusInMeters float64   `json:"radiusInMeters"`
+	Geometry       *Geometry `json:"geometry"`
+	VehiclesInZone []string  `json:"vehiclesInZone"`
+	// Vehicles are the vehicles in the zone with how long they have been there, longest first.
+	Vehicles []*ZoneOccupant `json:"vehicles"`
+	// Organizations are the ids of the organizations the geofence is assigned to.
+	Organizations []string `json:"organizations"`
 }
 
-type Notification struct {
+type ZoneOccupant struct {
 	VehicleId string `json:"vehicleId"`
-	OrgId     string `json:"orgId"`
-	OrgName   string `json:"orgName"`
-	ZoneName  string `json:"zoneName"`
-	Event     string `json:"event"`
+	// EnteredAt is the timestamp of the position the vehicle entered with, in milliseconds.
+	EnteredAt int64 `json:"enteredAt"`
+	// DwellMillis is the workflow time since the ENTER, up to the query. It keeps growing while
+	// the vehicle is silent, until it times out.
+	DwellMillis int64 `json:"dwellMillis"`
 }
 
-type CircularGeofence struct {
-	Name            string
-	CentralPoint    geo.Point
-	RadiousInMeters float64
+type Notification struct {
+	VehicleId     string `json:"vehicleId"`
+	OrgId         string `json:"orgId"`
+	OrgName       string `json:"orgName"`
+	TransportMode string `json:"transportMode"`
+	RouteId       string `json:"routeId,omitempty"`
+	DirectionId   string `json:"directionId,omitempty"`
+	Headsign      string `json:"headsign,omitempty"`
+	StopId        string `json:"stopId,omitempty"`
+	ZoneName      string `json:"zoneName"`
+	Event         string `json:"event"`
+	// DwellMillis is how long the vehicle has been in the zone, set on DWELL and EXIT.
+	DwellMillis int64 `json:"dwellMillis,omitempty"`
+	// Reason explains events that weren't caused by a position, such as a TIMEOUT EXIT.
+	Reason string `json:"reason,omitempty"`
 }
 
-func (geofence *CircularGeofence) IncludesPosition(latitude float64, longitude float64) bool {
-	point := geo.NewPoint(latitude, longitude)
-	return geofence.CentralPoint.GreatCircleDistance(point)*1000 < geofence.RadiousInMeters
+// GeofenceDefinition is a named geofence as it's configured and handed to workflows.
+type GeofenceDefinition struct {
+	Name     string    `json:"name"`
+	Geometry *Geometry `json:"geometry"`
+}
+
+// Shape decodes the geometry, it's also how a definition is validated.
+func (geofence *GeofenceDefinition) Shape() (GeofenceShape, error) {
+	if geofence.Name == "" {
+		return nil, fmt.Errorf("geofence has no name")
+	}
+	if geofence.Geometry == nil {
+		return nil, fmt.Errorf("geofence %v has no geometry", geofence.Name)
+	}
+
+	shape, err := geofence.Geometry.Shape()
+	if err != nil {
+		return nil, fmt.Errorf("geofence %v: %w", geofence.Name, err)
+	}
+	return shape, nil
+}
+
+// UnmarshalJSON also reads the circular geofences of workflow inputs written before geometries.
+func (geofence *GeofenceDefinition) UnmarshalJSON(data []byte) error {
+	type definition GeofenceDefinition
+	legacy := &struct {
+		*definition
+		CentralPoint    *geo.Point
+		RadiousInMeters float64
+	}{definition: (*definition)(geofence)}
+
+	if err := json.Unmarshal(data, legacy); err != nil {
+		return err
+	}
+
+	if geofence.Geometry == nil && legacy.CentralPoint != nil {
+		geofence.Geometry = NewCircleGeometry(legacy.CentralPoint.Lat(), legacy.CentralPoint.Lng(), legacy.RadiousInMeters)
+	}
+	return nil
 }
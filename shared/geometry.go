@@ -0,0 +1,406 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	geo "github.com/kellydunn/golang-geo"
+)
+
+const (
+	GeometryType_CIRCLE       = "Circle"
+	GeometryType_POLYGON      = "Polygon"
+	GeometryType_MULTIPOLYGON = "MultiPolygon"
+)
+
+const metersPerDegree = 111_320
+
+// GeofenceShape is the area of a geofence.
+type GeofenceShape interface {
+	IncludesPosition(latitude float64, longitude float64) bool
+	// DistanceInMeters is how far the position is from the edge, negative inside the shape.
+	DistanceInMeters(latitude float64, longitude float64) float64
+	BoundingBox() BoundingBox
+}
+
+// Geometry is the serializable form of a GeofenceShape, a GeoJSON geometry. Polygons and
+// multipolygons follow GeoJSON, the first ring of a polygon is its boundary and the others
+// are holes. Circles are the usual extension: a [longitude, latitude] centre and a radius.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	// Radius of circles, in meters.
+	Radius float64 `json:"radius,omitempty"`
+}
+
+func NewCircleGeometry(latitude float64, longitude float64, radiusInMeters float64) *Geometry {
+	coordinates, _ := json.Marshal([2]float64{longitude, latitude})
+	return &Geometry{
+		Type:        GeometryType_CIRCLE,
+		Coordinates: coordinates,
+		Radius:      radiusInMeters,
+	}
+}
+
+// NewPolygonGeometry takes rings of [longitude, latitude] pairs, boundary first.
+func NewPolygonGeometry(rings [][][2]float64) *Geometry {
+	coordinates, _ := json.Marshal(rings)
+	return &Geometry{
+		Type:        GeometryType_POLYGON,
+		Coordinates: coordinates,
+	}
+}
+
+// Equal compares geometries regardless of the formatting of their coordinates.
+func (g *Geometry) Equal(other *Geometry) bool {
+	if g == nil || other == nil {
+		return g == other
+	}
+	if g.Type != other.Type || g.Radius != other.Radius {
+		return false
+	}
+
+	coordinates, otherCoordinates := &bytes.Buffer{}, &bytes.Buffer{}
+	if json.Compact(coordinates, g.Coordinates) != nil || json.Compact(otherCoordinates, other.Coordinates) != nil {
+		return bytes.Equal(g.Coordinates, other.Coordinates)
+	}
+	return bytes.Equal(coordinates.Bytes(), otherCoordinates.Bytes())
+}
+
+// Shape decodes the geometry.
+func (g *Geometry) Shape() (GeofenceShape, error) {
+	switch g.Type {
+	case GeometryType_CIRCLE:
+		var centre [2]float64
+		if err := json.Unmarshal(g.Coordinates, &centre); err != nil {
+			return nil, fmt.Errorf("circle: %w", err)
+		}
+		if g.Radius <= 0 {
+			return nil, fmt.Errorf("circle: radius must be positive")
+		}
+		return NewCircle(centre[1], centre[0], g.Radius), nil
+	case GeometryType_POLYGON:
+		var rings [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return nil, fmt.Errorf("polygon: %w", err)
+		}
+		return NewPolygon(rings)
+	case GeometryType_MULTIPOLYGON:
+		var polygons [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polygons); err != nil {
+			return nil, fmt.Errorf("multipolygon: %w", err)
+		}
+		return NewMultiPolygon(polygons)
+	default:
+		return nil, fmt.Errorf("unknown geometry type %q", g.Type)
+	}
+}
+
+/*****
+	BOUNDING BOX
+*****/
+
+type BoundingBox struct {
+	MinLatitude  float64
+	MinLongitude float64
+	MaxLatitude  float64
+	MaxLongitude float64
+}
+
+// ParseBoundingBox parses "minLat,minLng,maxLat,maxLng".
+func ParseBoundingBox(s string) (BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BoundingBox{}, fmt.Errorf("bounding box %q must be minLat,minLng,maxLat,maxLng", s)
+	}
+
+	values := make([]float64, 4)
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return BoundingBox{}, fmt.Errorf("bounding box %q: %w", s, err)
+		}
+		values[i] = value
+	}
+
+	return BoundingBox{
+		MinLatitude:  values[0],
+		MinLongitude: values[1],
+		MaxLatitude:  values[2],
+		MaxLongitude: values[3],
+	}, nil
+}
+
+func (b BoundingBox) Contains(latitude float64, longitude float64) bool {
+	return latitude >= b.MinLatitude && latitude <= b.MaxLatitude &&
+		longitude >= b.MinLongitude && longitude <= b.MaxLongitude
+}
+
+func (b BoundingBox) Centre() (latitude float64, longitude float64) {
+	return (b.MinLatitude + b.MaxLatitude) / 2, (b.MinLongitude + b.MaxLongitude) / 2
+}
+
+// DistanceInMeters is zero inside the box. It's never more than the distance to anything
+// inside the box, so it's a cheap lower bound of the distance to the shape it encloses: no
+// path to the box is shorter than the latitude to cross or the distance to the great circle
+// of its nearest meridian, which also holds where meridians converge near the poles. The
+// result is discounted by 1% so the bound holds despite the spherical approximation.
+func (b BoundingBox) DistanceInMeters(latitude float64, longitude float64) float64 {
+	nearestLatitude := math.Max(b.MinLatitude, math.Min(latitude, b.MaxLatitude))
+	nearestLongitude := math.Max(b.MinLongitude, math.Min(longitude, b.MaxLongitude))
+	if nearestLatitude == latitude && nearestLongitude == longitude {
+		return 0
+	}
+
+	latitudeDistance := math.Abs(latitude-nearestLatitude) * metersPerDegree
+	// beyond 180 degrees the box is nearer the other way round, sin turns negative and the bound to 0
+	longitudeDelta := math.Abs(longitude-nearestLongitude) * math.Pi / 180
+	crossTrack := math.Max(0, math.Cos(latitude*math.Pi/180)*math.Sin(longitudeDelta))
+	longitudeDistance := math.Asin(math.Min(1, crossTrack)) * 180 / math.Pi * metersPerDegree
+	return math.Max(latitudeDistance, longitudeDistance) * 0.99
+}
+
+// Expand grows the box by meters on every side, a box reaching a pole spans every longitude.
+func (b BoundingBox) Expand(meters float64) BoundingBox {
+	latitudeDelta := meters / metersPerDegree
+	// meridians are closest at the edge nearest to the pole
+	edgeLatitude := math.Max(math.Abs(b.MinLatitude), math.Abs(b.MaxLatitude)) + latitudeDelta
+	if edgeLatitude >= 90 {
+		return BoundingBox{
+			MinLatitude:  math.Max(-90, b.MinLatitude-latitudeDelta),
+			MinLongitude: -180,
+			MaxLatitude:  math.Min(90, b.MaxLatitude+latitudeDelta),
+			MaxLongitude: 180,
+		}
+	}
+
+	longitudeDelta := meters / (metersPerDegree * math.Cos(edgeLatitude*math.Pi/180))
+	return BoundingBox{
+		MinLatitude:  b.MinLatitude - latitudeDelta,
+		MinLongitude: b.MinLongitude - longitudeDelta,
+		MaxLatitude:  b.MaxLatitude + latitudeDelta,
+		MaxLongitude: b.MaxLongitude + longitudeDelta,
+	}
+}
+
+func (b BoundingBox) extend(latitude float64, longitude float64) BoundingBox {
+	return BoundingBox{
+		MinLatitude:  math.Min(b.MinLatitude, latitude),
+		MinLongitude: math.Min(b.MinLongitude, longitude),
+		MaxLatitude:  math.Max(b.MaxLatitude, latitude),
+		MaxLongitude: math.Max(b.MaxLongitude, longitude),
+	}
+}
+
+func emptyBoundingBox() BoundingBox {
+	return BoundingBox{
+		MinLatitude:  math.Inf(1),
+		MinLongitude: math.Inf(1),
+		MaxLatitude:  math.Inf(-1),
+		MaxLongitude: math.Inf(-1),
+	}
+}
+
+// project maps a point to meters on a plane tangent at the reference latitude, plenty
+// accurate at the scale of a city.
+func project(latitude float64, longitude float64, referenceLatitude float64, referenceLongitude float64) (x float64, y float64) {
+	x = (longitude - referenceLongitude) * metersPerDegree * math.Cos(referenceLatitude*math.Pi/180)
+	y = (latitude - referenceLatitude) * metersPerDegree
+	return x, y
+}
+
+/*****
+	CIRCLE
+*****/
+
+type Circle struct {
+	Centre         *geo.Point
+	RadiusInMeters float64
+	boundingBox    BoundingBox
+}
+
+func NewCircle(latitude float64, longitude float64, radiusInMeters float64) *Circle {
+	centre := BoundingBox{MinLatitude: latitude, MinLongitude: longitude, MaxLatitude: latitude, MaxLongitude: longitude}
+	return &Circle{
+		Centre:         geo.NewPoint(latitude, longitude),
+		RadiusInMeters: radiusInMeters,
+		boundingBox:    centre.Expand(radiusInMeters),
+	}
+}
+
+func (c *Circle) IncludesPosition(latitude float64, longitude float64) bool {
+	return c.boundingBox.Contains(latitude, longitude) && c.DistanceInMeters(latitude, longitude) < 0
+}
+
+func (c *Circle) DistanceInMeters(latitude float64, longitude float64) float64 {
+	return c.Centre.GreatCircleDistance(geo.NewPoint(latitude, longitude))*1000 - c.RadiusInMeters
+}
+
+func (c *Circle) BoundingBox() BoundingBox {
+	return c.boundingBox
+}
+
+/*****
+	POLYGON
+*****/
+
+type Polygon struct {
+	// rings hold [longitude, latitude] pairs, the boundary first and then the holes.
+	rings       [][][2]float64
+	boundingBox BoundingBox
+}
+
+func NewPolygon(rings [][][2]float64) (*Polygon, error) {
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("polygon needs a boundary")
+	}
+
+	boundingBox := emptyBoundingBox()
+	for i, ring := range rings {
+		// GeoJSON rings repeat the first point at the end, both forms are accepted
+		if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+			ring = ring[:len(ring)-1]
+			rings[i] = ring
+		}
+		if len(ring) < 3 {
+			return nil, fmt.Errorf("polygon ring %v needs at least three points", i)
+		}
+		if i == 0 {
+			for _, point := range ring {
+				boundingBox = boundingBox.extend(point[1], point[0])
+			}
+		}
+	}
+
+	return &Polygon{
+		rings:       rings,
+		boundingBox: boundingBox,
+	}, nil
+}
+
+func (p *Polygon) IncludesPosition(latitude float64, longitude float64) bool {
+	if !p.boundingBox.Contains(latitude, longitude) {
+		return false
+	}
+
+	if !ringContains(p.rings[0], latitude, longitude) {
+		return false
+	}
+	for _, hole := range p.rings[1:] {
+		if ringContains(hole, latitude, longitude) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Polygon) DistanceInMeters(latitude float64, longitude float64) float64 {
+	distance := math.Inf(1)
+	for _, ring := range p.rings {
+		distance = math.Min(distance, ringDistance(ring, latitude, longitude))
+	}
+
+	if p.IncludesPosition(latitude, longitude) {
+		return -distance
+	}
+	return distance
+}
+
+func (p *Polygon) BoundingBox() BoundingBox {
+	return p.boundingBox
+}
+
+// ringContains casts a ray towards growing longitudes and counts the edges it crosses.
+func ringContains(ring [][2]float64, latitude float64, longitude float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > latitude) != (b[1] > latitude) &&
+			longitude < (b[0]-a[0])*(latitude-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// ringDistance is the distance to the nearest edge of the ring, in meters.
+func ringDistance(ring [][2]float64, latitude float64, longitude float64) float64 {
+	distance := math.Inf(1)
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		ax, ay := project(ring[i][1], ring[i][0], latitude, longitude)
+		bx, by := project(ring[j][1], ring[j][0], latitude, longitude)
+		distance = math.Min(distance, segmentDistance(ax, ay, bx, by))
+	}
+	return distance
+}
+
+// segmentDistance is the distance from the origin to the segment between a and b.
+func segmentDistance(ax float64, ay float64, bx float64, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if length := dx*dx + dy*dy; length > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+/*****
+	MULTIPOLYGON
+*****/
+
+type MultiPolygon struct {
+	polygons    []*Polygon
+	boundingBox BoundingBox
+}
+
+func NewMultiPolygon(polygons [][][][2]float64) (*MultiPolygon, error) {
+	if len(polygons) == 0 {
+		return nil, fmt.Errorf("multipolygon needs at least one polygon")
+	}
+
+	multiPolygon := &MultiPolygon{
+		polygons:    make([]*Polygon, 0, len(polygons)),
+		boundingBox: emptyBoundingBox(),
+	}
+	for i, rings := range polygons {
+		polygon, err := NewPolygon(rings)
+		if err != nil {
+			return nil, fmt.Errorf("multipolygon %v: %w", i, err)
+		}
+
+		multiPolygon.polygons = append(multiPolygon.polygons, polygon)
+		multiPolygon.boundingBox = multiPolygon.boundingBox.
+			extend(polygon.boundingBox.MinLatitude, polygon.boundingBox.MinLongitude).
+			extend(polygon.boundingBox.MaxLatitude, polygon.boundingBox.MaxLongitude)
+	}
+	return multiPolygon, nil
+}
+
+func (m *MultiPolygon) IncludesPosition(latitude float64, longitude float64) bool {
+	if !m.boundingBox.Contains(latitude, longitude) {
+		return false
+	}
+
+	for _, polygon := range m.polygons {
+		if polygon.IncludesPosition(latitude, longitude) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MultiPolygon) DistanceInMeters(latitude float64, longitude float64) float64 {
+	distance := math.Inf(1)
+	for _, polygon := range m.polygons {
+		distance = math.Min(distance, polygon.DistanceInMeters(latitude, longitude))
+	}
+	return distance
+}
+
+func (m *MultiPolygon) BoundingBox() BoundingBox {
+	return m.boundingBox
+}
@@ -0,0 +1,297 @@
+package shared
+
+import (
+	"math"
+	"testing"
+)
+
+type shapeCase struct {
+	name      string
+	latitude  float64
+	longitude float64
+	inside    bool
+	// distance is the expected signed distance to the edge, within a meter, zero on the edge.
+	distance float64
+}
+
+// runShapeCases checks inclusion and signed distance, and that the bounding box encloses
+// everything inside and never overestimates the distance.
+func runShapeCases(t *testing.T, shape GeofenceShape, cases []shapeCase) {
+	t.Helper()
+
+	for _, c := range cases {
+		// which side a point on the edge falls on is unspecified
+		if inside := shape.IncludesPosition(c.latitude, c.longitude); inside != c.inside && c.distance != 0 {
+			t.Errorf("%v: got inside %v, want %v", c.name, inside, c.inside)
+		}
+
+		distance := shape.DistanceInMeters(c.latitude, c.longitude)
+		if math.Abs(distance-c.distance) > 1 {
+			t.Errorf("%v: got distance %.1f m, want %.1f m", c.name, distance, c.distance)
+		}
+
+		boxDistance := shape.BoundingBox().DistanceInMeters(c.latitude, c.longitude)
+		if c.inside && boxDistance != 0 {
+			t.Errorf("%v: inside the shape but %.1f m from its bounding box", c.name, boxDistance)
+		}
+		if boxDistance > math.Max(0, distance) {
+			t.Errorf("%v: bounding box distance %.1f m exceeds the distance %.1f m", c.name, boxDistance, distance)
+		}
+	}
+}
+
+func newTestPolygon(t *testing.T, rings [][][2]float64) *Polygon {
+	t.Helper()
+
+	polygon, err := NewPolygon(rings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return polygon
+}
+
+func TestCircle(t *testing.T) {
+	tests := []struct {
+		name   string
+		circle *Circle
+		cases  []shapeCase
+	}{
+		{
+			name:   "helsinki",
+			circle: NewCircle(60.1687, 24.9316, 300),
+			cases: []shapeCase{
+				{"centre", 60.1687, 24.9316, true, -300},
+				{"north outside", 60.1737, 24.9316, false, 256},
+				{"far away", 60.2, 25.1, false, 9639.6},
+			},
+		},
+		{
+			// a degree of longitude shrinks to meters, the bounding box must still enclose the circle
+			name:   "near the north pole",
+			circle: NewCircle(89.99, 0, 500),
+			cases: []shapeCase{
+				{"towards the pole", 89.993, 0, true, -166.4},
+				{"ten degrees east", 89.99, 10, true, -306.2},
+				{"quarter around the pole", 89.99, 90, false, 1072.5},
+			},
+		},
+		{
+			// the bounding box of a circle around the pole spans every longitude
+			name:   "around the south pole",
+			circle: NewCircle(-89.999, 0, 500),
+			cases: []shapeCase{
+				{"across the pole", -89.999, 180, true, -277.6},
+				{"on the other side", -89.995, 120, false, 119.1},
+			},
+		},
+		{
+			name:   "near the antimeridian",
+			circle: NewCircle(-16.8, 179.99, 500),
+			cases: []shapeCase{
+				{"centre", -16.8, 179.99, true, -500},
+				{"east", -16.8, 179.994, true, -74.2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runShapeCases(t, test.circle, test.cases)
+		})
+	}
+}
+
+func TestPolygon(t *testing.T) {
+	tests := []struct {
+		name    string
+		polygon *Polygon
+		cases   []shapeCase
+	}{
+		{
+			name: "with hole",
+			polygon: newTestPolygon(t, [][][2]float64{
+				{{24.90, 60.10}, {25.00, 60.10}, {25.00, 60.20}, {24.90, 60.20}, {24.90, 60.10}},
+				{{24.94, 60.14}, {24.96, 60.14}, {24.96, 60.16}, {24.94, 60.16}},
+			}),
+			cases: []shapeCase{
+				{"inside next to the hole", 60.15, 24.93, true, -554.1},
+				{"in the hole", 60.15, 24.95, false, 554.1},
+				{"north outside", 60.25, 24.95, false, 5566},
+				{"on the south edge", 60.10, 24.95, false, 0},
+				{"on the edge of the hole", 60.16, 24.95, false, 0},
+			},
+		},
+		{
+			// concave, the ray of the notch crosses the boundary more than once
+			name: "u shaped",
+			polygon: newTestPolygon(t, [][][2]float64{
+				{{24.90, 60.10}, {24.93, 60.10}, {24.93, 60.17}, {24.97, 60.17}, {24.97, 60.10}, {25.00, 60.10}, {25.00, 60.20}, {24.90, 60.20}},
+			}),
+			cases: []shapeCase{
+				{"left arm", 60.12, 24.91, true, -554.6},
+				{"in the notch", 60.12, 24.95, false, 1109.2},
+				{"right arm", 60.12, 24.99, true, -554.6},
+			},
+		},
+		{
+			name: "near the antimeridian",
+			polygon: newTestPolygon(t, [][][2]float64{
+				{{179.90, -16.90}, {179.99, -16.90}, {179.99, -16.70}, {179.90, -16.70}},
+			}),
+			cases: []shapeCase{
+				{"inside", -16.8, 179.98, true, -1065.7},
+				{"east outside", -16.8, 179.995, false, 532.8},
+			},
+		},
+		{
+			name: "near the north pole",
+			polygon: newTestPolygon(t, [][][2]float64{
+				{{0, 89.90}, {90, 89.90}, {90, 89.95}, {0, 89.95}},
+			}),
+			cases: []shapeCase{
+				{"inside", 89.92, 45, true, -2226.4},
+				{"closer to the pole", 89.96, 45, false, 1113.2},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runShapeCases(t, test.polygon, test.cases)
+		})
+	}
+}
+
+func TestNewPolygonRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		rings [][][2]float64
+	}{
+		{"no rings", nil},
+		{"two point boundary", [][][2]float64{{{24.9, 60.1}, {25.0, 60.1}}}},
+		{"closed two point boundary", [][][2]float64{{{24.9, 60.1}, {25.0, 60.1}, {24.9, 60.1}}}},
+		{"two point hole", [][][2]float64{{{24.9, 60.1}, {25.0, 60.1}, {25.0, 60.2}}, {{24.95, 60.15}, {24.96, 60.15}}}},
+	}
+
+	for _, test := range tests {
+		if _, err := NewPolygon(test.rings); err == nil {
+			t.Errorf("%v: got no error", test.name)
+		}
+	}
+}
+
+func TestMultiPolygon(t *testing.T) {
+	multiPolygon, err := NewMultiPolygon([][][][2]float64{
+		{
+			{{24.90, 60.10}, {24.95, 60.10}, {24.95, 60.15}, {24.90, 60.15}},
+			{{24.92, 60.12}, {24.93, 60.12}, {24.93, 60.13}, {24.92, 60.13}},
+		},
+		{
+			{{25.00, 60.10}, {25.05, 60.10}, {25.05, 60.15}, {25.00, 60.15}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runShapeCases(t, multiPolygon, []shapeCase{
+		{"first polygon", 60.14, 24.94, true, -554.2},
+		{"hole of the first polygon", 60.125, 24.925, false, 277.3},
+		{"second polygon", 60.14, 25.04, true, -554.2},
+		// the bounding box of both holds the gap, the polygons don't
+		{"between them", 60.125, 24.975, false, 1386.2},
+	})
+
+	if _, err := NewMultiPolygon(nil); err == nil {
+		t.Error("multipolygon without polygons got no error")
+	}
+}
+
+func TestGeometryShape(t *testing.T) {
+	tests := []struct {
+		name     string
+		geometry *Geometry
+		valid    bool
+	}{
+		{"circle", NewCircleGeometry(60.1687, 24.9316, 300), true},
+		{"circle without radius", NewCircleGeometry(60.1687, 24.9316, 0), false},
+		{"polygon", NewPolygonGeometry([][][2]float64{{{24.9, 60.1}, {25.0, 60.1}, {25.0, 60.2}}}), true},
+		{"multipolygon", &Geometry{Type: GeometryType_MULTIPOLYGON, Coordinates: []byte(`[[[[24.9,60.1],[25.0,60.1],[25.0,60.2]]]]`)}, true},
+		{"malformed coordinates", &Geometry{Type: GeometryType_POLYGON, Coordinates: []byte(`[24.9,60.1]`)}, false},
+		{"unknown type", &Geometry{Type: "LineString", Coordinates: []byte(`[[24.9,60.1],[25.0,60.1]]`)}, false},
+	}
+
+	for _, test := range tests {
+		if _, err := test.geometry.Shape(); (err == nil) != test.valid {
+			t.Errorf("%v: got error %v, want valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestGeometryEqual(t *testing.T) {
+	circle := NewCircleGeometry(60.1687, 24.9316, 300)
+	reformatted := &Geometry{Type: GeometryType_CIRCLE, Coordinates: []byte(`[ 24.9316, 60.1687 ]`), Radius: 300}
+
+	if !circle.Equal(reformatted) {
+		t.Error("geometries differing only in formatting aren't equal")
+	}
+	if circle.Equal(NewCircleGeometry(60.1687, 24.9316, 301)) {
+		t.Error("circles of different radius are equal")
+	}
+	if circle.Equal(nil) || !(*Geometry)(nil).Equal(nil) {
+		t.Error("nil geometries compare wrong")
+	}
+}
+
+func TestBoundingBox(t *testing.T) {
+	box := BoundingBox{MinLatitude: 60.10, MinLongitude: 24.90, MaxLatitude: 60.20, MaxLongitude: 25.00}
+
+	tests := []struct {
+		name      string
+		latitude  float64
+		longitude float64
+		contains  bool
+		// distance is the expected distance to the box, within a meter.
+		distance float64
+	}{
+		{"inside", 60.15, 24.95, true, 0},
+		{"on the corner", 60.10, 24.90, true, 0},
+		{"north", 60.25, 24.95, false, 5510.3},
+		{"west", 60.15, 24.80, false, 5485.3},
+	}
+
+	for _, test := range tests {
+		if contains := box.Contains(test.latitude, test.longitude); contains != test.contains {
+			t.Errorf("%v: got contains %v, want %v", test.name, contains, test.contains)
+		}
+		if distance := box.DistanceInMeters(test.latitude, test.longitude); math.Abs(distance-test.distance) > 1 {
+			t.Errorf("%v: got distance %.1f m, want %.1f m", test.name, distance, test.distance)
+		}
+	}
+
+	if latitude, longitude := box.Centre(); math.Abs(latitude-60.15) > 1e-9 || math.Abs(longitude-24.95) > 1e-9 {
+		t.Errorf("got centre %v,%v, want 60.15,24.95", latitude, longitude)
+	}
+
+	expanded := box.Expand(1000)
+	if !expanded.Contains(60.2089, 24.95) || expanded.Contains(60.2091, 24.95) {
+		t.Errorf("expanding by 1 km north got %+v", expanded)
+	}
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	box, err := ParseBoundingBox("59.8, 23.9,60.8,25.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BoundingBox{MinLatitude: 59.8, MinLongitude: 23.9, MaxLatitude: 60.8, MaxLongitude: 25.9}
+	if box != want {
+		t.Errorf("got %+v, want %+v", box, want)
+	}
+
+	for _, s := range []string{"", "59.8,23.9,60.8", "59.8,23.9,60.8,east"} {
+		if _, err := ParseBoundingBox(s); err == nil {
+			t.Errorf("%q: got no error", s)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+)
+
+type OrderingMode string
+
+const (
+	// OrderingMode_STRICT only accepts positions newer than the last accepted one.
+	OrderingMode_STRICT OrderingMode = "strict"
+	// OrderingMode_TOLERANCE also accepts late positions within the tolerance window.
+	OrderingMode_TOLERANCE OrderingMode = "tolerance"
+)
+
+const (
+	DropReason_DUPLICATE    = "duplicate"
+	DropReason_OUT_OF_ORDER = "out_of_order"
+)
+
+type OrderingPolicy struct {
+	Mode            OrderingMode
+	ToleranceMillis int64
+}
+
+var DefaultOrderingPolicy = OrderingPolicy{Mode: OrderingMode_STRICT}
+
+func ParseOrderingMode(s string) (OrderingMode, error) {
+	switch mode := OrderingMode(s); mode {
+	case OrderingMode_STRICT, OrderingMode_TOLERANCE:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown ordering mode %q", s)
+	}
+}
+
+// OrderingKey identifies a message of a vehicle, a door event and a position may share
+// their timestamp.
+type OrderingKey struct {
+	Timestamp int64
+	// Event is the HFP event type, empty matches every type.
+	Event string
+}
+
+// OrderingFilter drops duplicate and out of order positions of a single vehicle. It's
+// deterministic, so it's safe to use inside workflows as well as in the ingress pipeline.
+type OrderingFilter struct {
+	Policy OrderingPolicy
+	// Last is the newest accepted timestamp.
+	Last int64
+	// Window holds the accepted keys inside the tolerance window, sorted by timestamp. In
+	// strict mode it only holds the ones at Last.
+	Window []OrderingKey
+	// Recent is the window of filters saved before event types were told apart, it's moved
+	// into Window on first use.
+	Recent []int64 `json:",omitempty"`
+}
+
+func NewOrderingFilter(policy OrderingPolicy) *OrderingFilter {
+	return &OrderingFilter{Policy: policy}
+}
+
+// Accept records the message when it's accepted, otherwise it returns the drop reason.
+func (f *OrderingFilter) Accept(event string, timestamp int64) (bool, string) {
+	f.migrate()
+	key := OrderingKey{Timestamp: timestamp, Event: event}
+
+	if f.Last == 0 || timestamp > f.Last {
+		f.Last = timestamp
+		f.remember(key)
+		return true, ""
+	}
+
+	if timestamp < f.Last && (f.Policy.Mode != OrderingMode_TOLERANCE || timestamp < f.Last-f.Policy.ToleranceMillis) {
+		return false, DropReason_OUT_OF_ORDER
+	}
+
+	for i := f.search(timestamp); i < len(f.Window) && f.Window[i].Timestamp == timestamp; i++ {
+		if f.Window[i].Event == event || f.Window[i].Event == "" {
+			return false, DropReason_DUPLICATE
+		}
+	}
+
+	f.remember(key)
+	return true, ""
+}
+
+func (f *OrderingFilter) remember(key OrderingKey) {
+	i := f.search(key.Timestamp + 1)
+	f.Window = append(f.Window, OrderingKey{})
+	copy(f.Window[i+1:], f.Window[i:])
+	f.Window[i] = key
+
+	// forget keys that fell out of the window
+	windowMillis := int64(0)
+	if f.Policy.Mode == OrderingMode_TOLERANCE {
+		windowMillis = f.Policy.ToleranceMillis
+	}
+	f.Window = f.Window[f.search(f.Last-windowMillis):]
+}
+
+// search returns the index of the first key at or after timestamp.
+func (f *OrderingFilter) search(timestamp int64) int {
+	return sort.Search(len(f.Window), func(i int) bool { return f.Window[i].Timestamp >= timestamp })
+}
+
+// migrate turns a saved timestamp only window into keys matching every event type.
+func (f *OrderingFilter) migrate() {
+	if f.Window != nil || f.Last == 0 {
+		return
+	}
+
+	f.Window = make([]OrderingKey, 0, len(f.Recent)+1)
+	for _, timestamp := range f.Recent {
+		f.Window = append(f.Window, OrderingKey{Timestamp: timestamp})
+	}
+	if len(f.Window) == 0 || f.Window[len(f.Window)-1].Timestamp != f.Last {
+		f.Window = append(f.Window, OrderingKey{Timestamp: f.Last})
+	}
+	f.Recent = nil
+}
@@ -0,0 +1,88 @@
+package shared
+
+import "testing"
+
+type orderingStep struct {
+	event     string
+	timestamp int64
+	accepted  bool
+	reason    string
+}
+
+func runOrderingSteps(t *testing.T, filter *OrderingFilter, steps []orderingStep) {
+	t.Helper()
+
+	for i, step := range steps {
+		accepted, reason := filter.Accept(step.event, step.timestamp)
+		if accepted != step.accepted || reason != step.reason {
+			t.Errorf("step %v, %v at %v: got %v %q, want %v %q", i, step.event, step.timestamp, accepted, reason, step.accepted, step.reason)
+		}
+	}
+}
+
+func TestOrderingFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OrderingPolicy
+		steps  []orderingStep
+	}{
+		{
+			name:   "strict",
+			policy: OrderingPolicy{Mode: OrderingMode_STRICT},
+			steps: []orderingStep{
+				{VehicleEvent_VP, 1000, true, ""},
+				{VehicleEvent_VP, 2000, true, ""},
+				{VehicleEvent_VP, 2000, false, DropReason_DUPLICATE},
+				// a door event and a position may share their timestamp
+				{VehicleEvent_DOO, 2000, true, ""},
+				{VehicleEvent_DOO, 2000, false, DropReason_DUPLICATE},
+				{VehicleEvent_VP, 1999, false, DropReason_OUT_OF_ORDER},
+				{VehicleEvent_VP, 3000, true, ""},
+				// the keys at 2000 fell out of the window, it's late now
+				{VehicleEvent_DOC, 2000, false, DropReason_OUT_OF_ORDER},
+			},
+		},
+		{
+			name:   "tolerance",
+			policy: OrderingPolicy{Mode: OrderingMode_TOLERANCE, ToleranceMillis: 1000},
+			steps: []orderingStep{
+				{VehicleEvent_VP, 5000, true, ""},
+				{VehicleEvent_VP, 4500, true, ""},
+				{VehicleEvent_VP, 4500, false, DropReason_DUPLICATE},
+				{VehicleEvent_VP, 4000, true, ""},
+				{VehicleEvent_VP, 3999, false, DropReason_OUT_OF_ORDER},
+				{VehicleEvent_VP, 5000, false, DropReason_DUPLICATE},
+				{VehicleEvent_VP, 6000, true, ""},
+				// moving on slid 4000 out of the window
+				{VehicleEvent_VP, 4500, false, DropReason_OUT_OF_ORDER},
+				{VehicleEvent_VP, 5000, false, DropReason_DUPLICATE},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runOrderingSteps(t, NewOrderingFilter(test.policy), test.steps)
+		})
+	}
+}
+
+// Filters saved before event types were told apart only have Recent, its keys match every event.
+func TestOrderingFilterMigratesRecent(t *testing.T) {
+	filter := &OrderingFilter{
+		Policy: OrderingPolicy{Mode: OrderingMode_TOLERANCE, ToleranceMillis: 1000},
+		Last:   5000,
+		Recent: []int64{4200, 4800},
+	}
+
+	runOrderingSteps(t, filter, []orderingStep{
+		{VehicleEvent_DOO, 4800, false, DropReason_DUPLICATE},
+		{VehicleEvent_VP, 4200, false, DropReason_DUPLICATE},
+		{VehicleEvent_VP, 5000, false, DropReason_DUPLICATE},
+		{VehicleEvent_VP, 4500, true, ""},
+	})
+
+	if filter.Recent != nil {
+		t.Errorf("Recent wasn't migrated: %v", filter.Recent)
+	}
+}
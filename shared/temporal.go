@@ -5,8 +5,16 @@ const (
 	OrganizationSignal = "OrganizationSignal"
 	GeofenceSignal     = "GeofenceSignal"
 	NotificationSignal = "NotificationSignal"
+	// GeofenceChangedSignal carries the new definition of a geofence to the organizations it's assigned to.
+	GeofenceChangedSignal = "GeofenceChangedSignal"
 )
 
+// VehicleEventSignal is the name of the signal carrying vehicle events of the given type,
+// each event type has its own signal channel on the Vehicle workflow.
+func VehicleEventSignal(eventType string) string {
+	return "VehicleEventSignal_" + eventType
+}
+
 const (
 	RealtimeMapTaskQueue = "realtimemap_task_queue"
 )
@@ -14,9 +22,75 @@ const (
 const (
 	VehiclePositionHistoryQuery = "get_position_history"
 	GeofencesQuery              = "get_geofences"
+	OrganizationQuery           = "get_organization"
+)
+
+const (
+	UpdateGeofenceUpdate     = "update_geofence"
+	DeleteGeofenceUpdate     = "delete_geofence"
+	AssignGeofenceUpdate     = "assign_geofence"
+	UnassignGeofenceUpdate   = "unassign_geofence"
+	RenameOrganizationUpdate = "rename_organization"
+	DeleteOrganizationUpdate = "delete_organization"
+	// AssignOrganizationUpdate and UnassignOrganizationUpdate keep track on the geofence of the
+	// organizations it's assigned to.
+	AssignOrganizationUpdate   = "assign_organization"
+	UnassignOrganizationUpdate = "unassign_organization"
+	// ConfigureRoutingUpdate hands an organization the geofence settings its routing follows.
+	ConfigureRoutingUpdate = "configure_routing"
+	// ConfigureGeofenceUpdate hands a running geofence new settings.
+	ConfigureGeofenceUpdate = "configure_geofence"
 )
 
 const (
 	GeofenceEvent_ENTER = "ENTER"
 	GeofenceEvent_EXIT  = "EXIT"
+	GeofenceEvent_DWELL = "DWELL"
 )
+
+const (
+	// ExitReason_TIMEOUT marks an EXIT of a vehicle that stopped reporting inside the zone.
+	ExitReason_TIMEOUT = "TIMEOUT"
+)
+
+const (
+	VehicleEvent_VP    = "VP"
+	VehicleEvent_DOO   = "DOO"
+	VehicleEvent_DOC   = "DOC"
+	VehicleEvent_ARR   = "ARR"
+	VehicleEvent_DEP   = "DEP"
+	VehicleEvent_ARS   = "ARS"
+	VehicleEvent_PDE   = "PDE"
+	VehicleEvent_PAS   = "PAS"
+	VehicleEvent_WAIT  = "WAIT"
+	VehicleEvent_DUE   = "DUE"
+	VehicleEvent_TLR   = "TLR"
+	VehicleEvent_TLA   = "TLA"
+	VehicleEvent_VJA   = "VJA"
+	VehicleEvent_VJOUT = "VJOUT"
+	// driver and block sign in and out at the vehicle terminal
+	VehicleEvent_DA   = "DA"
+	VehicleEvent_DOUT = "DOUT"
+	VehicleEvent_BA   = "BA"
+	VehicleEvent_BOUT = "BOUT"
+)
+
+// VehicleEventTypes lists the events that are delivered to the Vehicle workflow as VehicleEvent
+// rather than as a plain position.
+var VehicleEventTypes = []string{
+	VehicleEvent_ARR,
+	VehicleEvent_DEP,
+	VehicleEvent_ARS,
+	VehicleEvent_PDE,
+	VehicleEvent_PAS,
+	VehicleEvent_WAIT,
+	VehicleEvent_DUE,
+	VehicleEvent_TLR,
+	VehicleEvent_TLA,
+	VehicleEvent_VJA,
+	VehicleEvent_VJOUT,
+	VehicleEvent_DA,
+	VehicleEvent_DOUT,
+	VehicleEvent_BA,
+	VehicleEvent_BOUT,
+}
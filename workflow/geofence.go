@@ -2,15 +2,159 @@ package workflow
 
 import (
 	"context"
-	"realtimemap-temporal/data"
 	"realtimemap-temporal/shared"
+	"sort"
+	"time"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/workflow"
 )
 
+// GeofenceStateVersion is bumped whenever GeofenceState changes in a way older workflows can't read.
+const GeofenceStateVersion = 2
+
+// legacyGeofenceStateVersion is the state of runs without hysteresis. Its occupancy also holds
+// every vehicle seen outside the zone, so only the organizations are migrated and the vehicles
+// inside enter again with their next positions.
+const legacyGeofenceStateVersion = 1
+
+// GeofenceHysteresis keeps GPS jitter at the edge of a zone from toggling ENTER and EXIT.
+// A vehicle enters after EntryFixes consecutive positions at least EntryBufferMeters inside
+// the edge and exits after ExitFixes consecutive positions more than ExitBufferMeters outside.
+type GeofenceHysteresis struct {
+	EntryBufferMeters float64
+	ExitBufferMeters  float64
+	EntryFixes        int
+	ExitFixes         int
+}
+
+var DefaultGeofenceHysteresis = GeofenceHysteresis{
+	ExitBufferMeters: 25,
+	EntryFixes:       1,
+	ExitFixes:        2,
+}
+
+const (
+	// DefaultDwellThreshold is how long a vehicle stays in a zone before a DWELL notification.
+	DefaultDwellThreshold = 5 * time.Minute
+	// DefaultVehicleTTL is how long a vehicle in a zone may stay silent before it's evicted.
+	DefaultVehicleTTL = 10 * time.Minute
+)
+
+// GeofenceSettings are the knobs shared by every geofence.
+type GeofenceSettings struct {
+	Hysteresis     GeofenceHysteresis
+	DwellThreshold time.Duration
+	VehicleTTL     time.Duration
+}
+
+var DefaultGeofenceSettings = GeofenceSettings{
+	Hysteresis:     DefaultGeofenceHysteresis,
+	DwellThreshold: DefaultDwellThreshold,
+	VehicleTTL:     DefaultVehicleTTL,
+}
+
+func (settings GeofenceSettings) input(geofence *shared.GeofenceDefinition) *GeofenceInput {
+	return &GeofenceInput{
+		Geofence:       geofence,
+		Hysteresis:     settings.Hysteresis,
+		DwellThreshold: settings.DwellThreshold,
+		VehicleTTL:     settings.VehicleTTL,
+	}
+}
+
 type GeofenceInput struct {
-	Geofence *shared.CircularGeofence
+	Geofence   *shared.GeofenceDefinition
+	Hysteresis GeofenceHysteresis
+	// DwellThreshold is how long a vehicle stays in the zone before a DWELL notification, zero disables it.
+	DwellThreshold time.Duration
+	// VehicleTTL evicts vehicles that sent no position for that long with a TIMEOUT EXIT, zero disables it.
+	VehicleTTL time.Duration
+	// Version of State, inputs written before state was carried over have neither.
+	Version int
+	// State is handed over from the previous run on continue-as-new.
+	State *GeofenceState
+}
+
+type GeofenceState struct {
+	// VehiclesInZone are INSIDE, every other vehicle is OUTSIDE.
+	VehiclesInZone map[string]*ZoneVisit
+	// Approaching counts the consecutive entry fixes of vehicles still OUTSIDE.
+	Approaching map[string]int
+	// ApproachingAt is the workflow time of the last entry fix of each approaching vehicle,
+	// vehicles silent for the TTL are forgotten.
+	ApproachingAt map[string]time.Time
+	// Organizations the geofence is assigned to, it can't be deleted while there are any.
+	Organizations map[string]struct{}
+}
+
+type ZoneVisit struct {
+	// EnteredAt is the timestamp of the position that made the vehicle enter, in milliseconds.
+	EnteredAt int64
+	// EnteredReportedAt is the workflow time of the ENTER, the DWELL timer counts from it.
+	EnteredReportedAt time.Time
+	// LastSeenAt is the timestamp of the last position in the zone, in milliseconds.
+	LastSeenAt int64
+	// ExitFixes counts the consecutive exit fixes since the vehicle was last seen inside.
+	ExitFixes int
+	// Dwelled is set once the DWELL notification of this visit was sent.
+	Dwelled bool
+	// LastPosition is the last position received, also outside the zone, it fills the TIMEOUT EXIT.
+	LastPosition *shared.Position
+	// LastReportedAt is the workflow time of LastPosition, the TTL counts from it.
+	LastReportedAt time.Time
+}
+
+func (visit *ZoneVisit) dwellMillis() int64 {
+	return max(0, visit.LastSeenAt-visit.EnteredAt)
+}
+
+// enteredAt returns the workflow time of the ENTER, visits carried over from before it was
+// kept count from the timestamp of their first position.
+func (visit *ZoneVisit) enteredAt() time.Time {
+	if visit.EnteredReportedAt.IsZero() {
+		return time.UnixMilli(visit.EnteredAt)
+	}
+	return visit.EnteredReportedAt
+}
+
+// restoreGeofenceState returns the state carried by input, or a fresh one when there's none or
+// it was written by an unknown version.
+func restoreGeofenceState(ctx workflow.Context, input *GeofenceInput) *GeofenceState {
+	state := &GeofenceState{
+		VehiclesInZone: make(map[string]*ZoneVisit),
+		Approaching:    make(map[string]int),
+		ApproachingAt:  make(map[string]time.Time),
+		Organizations:  make(map[string]struct{}),
+	}
+
+	switch {
+	case input.State == nil:
+		return state
+	case input.Version == legacyGeofenceStateVersion:
+		for orgID := range input.State.Organizations {
+			state.Organizations[orgID] = struct{}{}
+		}
+		workflow.GetLogger(ctx).Info("Discarding legacy geofence occupancy", "vehicles", len(input.State.VehiclesInZone))
+		return state
+	case input.Version != GeofenceStateVersion:
+		workflow.GetLogger(ctx).Warn("Discarding geofence state of another version", "version", input.Version)
+		return state
+	}
+
+	if input.State.VehiclesInZone == nil {
+		input.State.VehiclesInZone = state.VehiclesInZone
+	}
+	if input.State.Approaching == nil {
+		input.State.Approaching = state.Approaching
+	}
+	if input.State.ApproachingAt == nil {
+		input.State.ApproachingAt = state.ApproachingAt
+	}
+	if input.State.Organizations == nil {
+		input.State.Organizations = state.Organizations
+	}
+	return input.State
 }
 
 type GeofenceOutput struct{}
@@ -19,26 +163,57 @@ type GetGeofenceRequest struct{}
 
 type GetGeofenceResponse struct {
 	Geofence *shared.Geofence
+	// Settings the geofence runs with, reconciling compares them with the current ones.
+	Settings GeofenceSettings
 }
 
 func Geofence(ctx workflow.Context, input *GeofenceInput) (*GeofenceOutput, error) {
 	log := workflow.GetLogger(ctx)
 
 	log.Info("Geofence workflow started")
+	// runs started before visits were tracked keep toggling ENTER and EXIT on the edge of the
+	// zone, without DWELL and without timing vehicles out
+	tracksVisits := workflow.GetVersion(ctx, geofenceVisitsChange, workflow.DefaultVersion, 1) == 1
 	geofence := input.Geofence
-	vehiclesInZone := make(map[string]struct{})
+	hysteresis, dwellThreshold, vehicleTTL := input.Hysteresis, input.DwellThreshold, input.VehicleTTL
+	shape, err := geofence.Shape()
+	if err != nil {
+		log.Error("Invalid geofence geometry", "error", err)
+		return nil, err
+	}
+	state := restoreGeofenceState(ctx, input)
+	vehiclesInZone, approaching, approachingAt := state.VehiclesInZone, state.Approaching, state.ApproachingAt
+	organizations := state.Organizations
+	evictsSilent := tracksVisits && vehicleTTL > 0
+	// timers run in timerCtx, it's canceled when the settings change and the timers are rearmed
+	timerCtx, cancelTimers := workflow.WithCancel(ctx)
 
 	/*****
 		QUERY
 	*****/
-	err := workflow.SetQueryHandler(ctx, shared.GeofencesQuery, func(request *GetGeofenceRequest) (*GetGeofenceResponse, error) {
+	err = workflow.SetQueryHandler(ctx, shared.GeofencesQuery, func(request *GetGeofenceRequest) (*GetGeofenceResponse, error) {
+		centreLatitude, centreLongitude := shape.BoundingBox().Centre()
+		radius := 0.0
+		if circle, ok := shape.(*shared.Circle); ok {
+			centreLatitude, centreLongitude = circle.Centre.Lat(), circle.Centre.Lng()
+			radius = circle.RadiusInMeters
+		}
+
 		return &GetGeofenceResponse{
 			Geofence: &shared.Geofence{
 				Name:           geofence.Name,
-				RadiusInMeters: geofence.RadiousInMeters,
-				Latitude:       geofence.CentralPoint.Lat(),
-				Longitude:      geofence.CentralPoint.Lng(),
+				RadiusInMeters: radius,
+				Latitude:       centreLatitude,
+				Longitude:      centreLongitude,
+				Geometry:       geofence.Geometry,
 				VehiclesInZone: getMapKeys(vehiclesInZone),
+				Vehicles:       getZoneOccupants(vehiclesInZone, workflow.Now(ctx)),
+				Organizations:  sortedKeys(organizations),
+			},
+			Settings: GeofenceSettings{
+				Hysteresis:     hysteresis,
+				DwellThreshold: dwellThreshold,
+				VehicleTTL:     vehicleTTL,
 			},
 		}, nil
 	})
@@ -48,93 +223,440 @@ func Geofence(ctx workflow.Context, input *GeofenceInput) (*GeofenceOutput, erro
 	}
 
 	/*****
-		SELECTOR
+		SIGNALS
 	*****/
-	selector := workflow.NewSelector(ctx)
-
-	selector.AddReceive(workflow.GetSignalChannel(ctx, shared.GeofenceSignal), func(c workflow.ReceiveChannel, more bool) {
-		position := &shared.Position{}
-		c.Receive(ctx, position)
-
-		_, vehicleIsInZone := vehiclesInZone[position.VehicleId]
-
-		if geofence.IncludesPosition(position.Latitude, position.Longitude) {
-			if !vehicleIsInZone {
-				vehiclesInZone[position.VehicleId] = struct{}{}
-				workflow.SignalExternalWorkflow(
-					ctx,
-					GetNotificationWorkflowID(),
-					"",
-					shared.NotificationSignal,
-					&shared.Notification{
-						VehicleId: position.VehicleId,
-						OrgId:     position.OrgId,
-						OrgName:   position.OrgName,
-						ZoneName:  geofence.Name,
-						Event:     shared.GeofenceEvent_ENTER,
-					},
-				)
-			}
-		} else {
-			delete(vehiclesInZone, position.VehicleId)
-			vehiclesInZone[position.VehicleId] = struct{}{}
+	signals := newSignalLoop(ctx)
+	deleted := false
+
+	/*****
+		UPDATES
+	*****/
+	// vehicles in the zone stay, the next position of each one is checked against the new shape.
+	// The organizations get the new definition from the update itself, so none of them is missed.
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.UpdateGeofenceUpdate, func(ctx workflow.Context, definition *shared.GeofenceDefinition) error {
+		updated, err := definition.Shape()
+		if err != nil {
+			return err
+		}
+		shape, geofence = updated, definition
+
+		// sorted, signals must be sent in the same order on replay
+		for _, orgID := range sortedKeys(organizations) {
 			workflow.SignalExternalWorkflow(
-				ctx,
-				GetNotificationWorkflowID(),
-				"",
-				shared.NotificationSignal,
-				&shared.Notification{
-					VehicleId: position.VehicleId,
-					OrgId:     position.OrgId,
-					OrgName:   position.OrgName,
-					ZoneName:  geofence.Name,
-					Event:     shared.GeofenceEvent_EXIT,
-				},
+				ctx,                              // context
+				GetOrganizationWorkflowID(orgID), // workflow id
+				"",                               // run id
+				shared.GeofenceChangedSignal,     // signal name
+				definition,                       // signal argument
 			)
 		}
+		log.Info("Geofence updated", "organizations", len(organizations))
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, definition *shared.GeofenceDefinition) error {
+			if definition == nil || definition.Name != geofence.Name {
+				return validationError("geofence %v can't be renamed", geofence.Name)
+			}
+			if _, err := definition.Shape(); err != nil {
+				return validationError("%v", err)
+			}
+			return nil
+		},
 	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
 
-	for {
-		selector.Select(ctx)
-		// we'll continue this workflow as new one when reaching history length and size limit
-		if workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
-			// after draining all events
-			if !selector.HasPending() {
-				break
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.AssignOrganizationUpdate, func(ctx workflow.Context, orgID string) (*shared.GeofenceDefinition, error) {
+		organizations[orgID] = struct{}{}
+		return geofence, nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, orgID string) error {
+			if orgID == "" {
+				return validationError("organization id is missing")
 			}
+			if deleted {
+				return validationError("geofence %v is deleted", geofence.Name)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandler(ctx, shared.UnassignOrganizationUpdate, func(ctx workflow.Context, orgID string) error {
+		delete(organizations, orgID)
+		return nil
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.DeleteGeofenceUpdate, func(ctx workflow.Context) error {
+		deleted = true
+		signals.stop()
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context) error {
+			if len(organizations) > 0 {
+				return validationError("geofence %v is still assigned to organizations %v", geofence.Name, sortedKeys(organizations))
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	notify := func(position *shared.Position, event string, dwellMillis int64, reason string) {
+		workflow.SignalExternalWorkflow(
+			ctx,
+			GetNotificationWorkflowID(),
+			"",
+			shared.NotificationSignal,
+			&shared.Notification{
+				VehicleId:     position.VehicleId,
+				OrgId:         position.OrgId,
+				OrgName:       position.OrgName,
+				TransportMode: position.TransportMode,
+				RouteId:       position.RouteId,
+				DirectionId:   position.DirectionId,
+				Headsign:      position.Headsign,
+				ZoneName:      geofence.Name,
+				Event:         event,
+				DwellMillis:   dwellMillis,
+				Reason:        reason,
+			},
+		)
+	}
+
+	// every visit has one timer, when it fires early because the vehicle kept reporting it's
+	// rearmed for the rest of the TTL instead of restarting a timer on every position
+	var watchVisit func(vehicleID string, visit *ZoneVisit, after time.Duration)
+	watchVisit = func(vehicleID string, visit *ZoneVisit, after time.Duration) {
+		signals.addFuture(workflow.NewTimer(timerCtx, after), func(f workflow.Future) {
+			// the settings changed, or the vehicle left or left and came back with a visit of its own
+			if f.Get(ctx, nil) != nil || vehiclesInZone[vehicleID] != visit {
+				return
+			}
+
+			if idle := workflow.Now(ctx).Sub(visit.LastReportedAt); idle < vehicleTTL {
+				watchVisit(vehicleID, visit, vehicleTTL-idle)
+				return
+			}
+
+			delete(vehiclesInZone, vehicleID)
+			notify(visit.LastPosition, shared.GeofenceEvent_EXIT, visit.dwellMillis(), shared.ExitReason_TIMEOUT)
+		})
+	}
+
+	// DWELL is sent once the vehicle spent dwellThreshold in the zone, also when it stopped reporting
+	watchDwell := func(vehicleID string, visit *ZoneVisit, after time.Duration) {
+		signals.addFuture(workflow.NewTimer(timerCtx, after), func(f workflow.Future) {
+			if f.Get(ctx, nil) != nil || vehiclesInZone[vehicleID] != visit || visit.Dwelled {
+				return
+			}
+
+			visit.Dwelled = true
+			notify(visit.LastPosition, shared.GeofenceEvent_DWELL, workflow.Now(ctx).Sub(visit.enteredAt()).Milliseconds(), "")
+		})
+	}
+
+	// a single timer forgets the approaching vehicles that went silent, it's armed while there are any
+	sweeping := false
+	var sweepApproaching func()
+	sweepApproaching = func() {
+		sweeping = true
+		signals.addFuture(workflow.NewTimer(timerCtx, vehicleTTL), func(f workflow.Future) {
+			if f.Get(ctx, nil) != nil {
+				return
+			}
+			sweeping = false
+			now := workflow.Now(ctx)
+			for _, vehicleID := range sortedKeys(approaching) {
+				if now.Sub(approachingAt[vehicleID]) >= vehicleTTL {
+					delete(approaching, vehicleID)
+					delete(approachingAt, vehicleID)
+				}
+			}
+			if len(approaching) > 0 {
+				sweepApproaching()
+			}
+		})
+	}
+
+	// armTimers starts the timers of the vehicles in the state, carried over or reconfigured
+	armTimers := func() {
+		if evictsSilent {
+			now := workflow.Now(ctx)
+			// sorted, timers must be started in the same order on replay
+			for _, vehicleID := range sortedKeys(vehiclesInZone) {
+				visit := vehiclesInZone[vehicleID]
+				// visits carried over from before eviction have neither, count from now
+				if visit.LastReportedAt.IsZero() {
+					visit.LastReportedAt = now
+				}
+				if visit.LastPosition == nil {
+					visit.LastPosition = &shared.Position{VehicleId: vehicleID}
+				}
+				watchVisit(vehicleID, visit, max(0, vehicleTTL-now.Sub(visit.LastReportedAt)))
+			}
+		}
+
+		if evictsSilent && len(approaching) > 0 {
+			now := workflow.Now(ctx)
+			// approaching vehicles carried over from before they were timed have no time, count from now
+			for vehicleID := range approaching {
+				if _, ok := approachingAt[vehicleID]; !ok {
+					approachingAt[vehicleID] = now
+				}
+			}
+			sweepApproaching()
+		}
+
+		if tracksVisits && dwellThreshold > 0 {
+			now := workflow.Now(ctx)
+			for _, vehicleID := range sortedKeys(vehiclesInZone) {
+				visit := vehiclesInZone[vehicleID]
+				if visit.Dwelled {
+					continue
+				}
+				if visit.LastPosition == nil {
+					visit.LastPosition = &shared.Position{VehicleId: vehicleID}
+				}
+				watchDwell(vehicleID, visit, max(0, dwellThreshold-now.Sub(visit.enteredAt())))
+			}
+		}
+	}
+	armTimers()
+
+	// the next positions are judged with the new settings, the timers of the vehicles in the zone
+	// are restarted to count the new durations from the same start. They belong to the workflow,
+	// not to the update.
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.ConfigureGeofenceUpdate, func(_ workflow.Context, settings *GeofenceSettings) error {
+		hysteresis, dwellThreshold, vehicleTTL = settings.Hysteresis, settings.DwellThreshold, settings.VehicleTTL
+		evictsSilent = tracksVisits && vehicleTTL > 0
+
+		cancelTimers()
+		timerCtx, cancelTimers = workflow.WithCancel(ctx)
+		sweeping = false
+		armTimers()
+		log.Info("Geofence configured", "exitBufferMeters", hysteresis.ExitBufferMeters, "dwellThreshold", dwellThreshold, "vehicleTTL", vehicleTTL)
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, settings *GeofenceSettings) error {
+			if settings == nil {
+				return validationError("geofence settings are missing")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	handleSignal(ctx, signals, shared.GeofenceSignal, func(position *shared.Position) {
+		if !tracksVisits {
+			// every position outside sends an EXIT and keeps the vehicle, as it always did
+			if !shape.IncludesPosition(position.Latitude, position.Longitude) {
+				vehiclesInZone[position.VehicleId] = &ZoneVisit{EnteredAt: position.Timestamp}
+				notify(position, shared.GeofenceEvent_EXIT, 0, "")
+			} else if _, ok := vehiclesInZone[position.VehicleId]; !ok {
+				vehiclesInZone[position.VehicleId] = &ZoneVisit{EnteredAt: position.Timestamp}
+				notify(position, shared.GeofenceEvent_ENTER, 0, "")
+			}
+			return
+		}
+
+		// the bounding box gives a lower bound of the distance, exact only matters near the edge
+		distance := shape.BoundingBox().DistanceInMeters(position.Latitude, position.Longitude)
+		if distance <= hysteresis.ExitBufferMeters {
+			distance = shape.DistanceInMeters(position.Latitude, position.Longitude)
+		}
+
+		// INSIDE -> OUTSIDE
+		if visit, ok := vehiclesInZone[position.VehicleId]; ok {
+			visit.LastPosition, visit.LastReportedAt = position, workflow.Now(ctx)
+
+			if distance <= hysteresis.ExitBufferMeters {
+				visit.ExitFixes = 0
+				visit.LastSeenAt = max(visit.LastSeenAt, position.Timestamp)
+				return
+			}
+
+			visit.ExitFixes++
+			if visit.ExitFixes >= max(1, hysteresis.ExitFixes) {
+				delete(vehiclesInZone, position.VehicleId)
+				visit.LastSeenAt = max(visit.LastSeenAt, position.Timestamp)
+				notify(position, shared.GeofenceEvent_EXIT, visit.dwellMillis(), "")
+			}
+			return
+		}
+
+		// OUTSIDE -> INSIDE
+		if distance >= -hysteresis.EntryBufferMeters {
+			delete(approaching, position.VehicleId)
+			delete(approachingAt, position.VehicleId)
+			return
+		}
+
+		approaching[position.VehicleId]++
+		approachingAt[position.VehicleId] = workflow.Now(ctx)
+		if approaching[position.VehicleId] >= max(1, hysteresis.EntryFixes) {
+			delete(approaching, position.VehicleId)
+			delete(approachingAt, position.VehicleId)
+			now := workflow.Now(ctx)
+			visit := &ZoneVisit{
+				EnteredAt:         position.Timestamp,
+				EnteredReportedAt: now,
+				LastSeenAt:        position.Timestamp,
+				LastPosition:      position,
+				LastReportedAt:    now,
+			}
+			vehiclesInZone[position.VehicleId] = visit
+			notify(position, shared.GeofenceEvent_ENTER, 0, "")
+
+			if dwellThreshold > 0 {
+				watchDwell(position.VehicleId, visit, dwellThreshold)
+			}
+
+			if evictsSilent {
+				watchVisit(position.VehicleId, visit, vehicleTTL)
+			}
+		} else if evictsSilent && !sweeping {
+			sweepApproaching()
 		}
-		// if you want to test the logic of continuing workflow as new, please change the condition to
-		// "workflow.GetInfo(ctx).GetCurrentHistoryLength() > 100", it'll create new workflow
-		// when history length is at least 100
+	})
+
+	signals.run(ctx)
+
+	if deleted {
+		log.Info("Geofence deleted", "vehiclesInZone", len(vehiclesInZone))
+		return &GeofenceOutput{}, nil
 	}
 
-	return nil, workflow.NewContinueAsNewError(ctx, Geofence, input)
+	stateVersion := GeofenceStateVersion
+	if !tracksVisits {
+		stateVersion = legacyGeofenceStateVersion
+	}
+
+	log.Info("Continuing geofence workflow as new", "vehiclesInZone", len(vehiclesInZone))
+	return nil, workflow.NewContinueAsNewError(ctx, Geofence, &GeofenceInput{
+		Geofence:       geofence,
+		Hysteresis:     hysteresis,
+		DwellThreshold: dwellThreshold,
+		VehicleTTL:     vehicleTTL,
+		Version:        stateVersion,
+		State: &GeofenceState{
+			VehiclesInZone: vehiclesInZone,
+			Approaching:    approaching,
+			ApproachingAt:  approachingAt,
+			Organizations:  organizations,
+		},
+	})
 }
 
-func InitGeofence(ctx context.Context, temporalClient client.Client) error {
+// CreateGeofence starts the workflow of a new geofence, it fails when the geofence already exists.
+func CreateGeofence(ctx context.Context, temporalClient client.Client, settings GeofenceSettings, geofence *shared.GeofenceDefinition) error {
+	return createGeofence(ctx, temporalClient, settings, geofence, "")
+}
+
+// createGeofence is CreateGeofence recording managedBy in the memo, empty records nothing.
+func createGeofence(ctx context.Context, temporalClient client.Client, settings GeofenceSettings, geofence *shared.GeofenceDefinition, managedBy string) error {
+	if _, err := geofence.Shape(); err != nil {
+		return err
+	}
+
 	startWorkflowOpts := client.StartWorkflowOptions{
-		TaskQueue: shared.RealtimeMapTaskQueue,
-	}
-
-	for _, geofence := range data.AllGeofences {
-		startWorkflowOpts.ID = GetGeofenceWorkflowID(geofence.Name)
-		_, err := temporalClient.ExecuteWorkflow(
-			ctx,               // context
-			startWorkflowOpts, // start workflow options
-			Geofence,          // workflow
-			&GeofenceInput{
-				Geofence: geofence,
-			}, // workflow argument
-		)
-		if err != nil {
-			return err
-		}
+		ID:                                       GetGeofenceWorkflowID(geofence.Name),
+		TaskQueue:                                shared.RealtimeMapTaskQueue,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	if managedBy != "" {
+		startWorkflowOpts.Memo = map[string]interface{}{managedByMemo: managedBy}
+	}
+	_, err := temporalClient.ExecuteWorkflow(
+		ctx,                      // context
+		startWorkflowOpts,        // start workflow options
+		Geofence,                 // workflow
+		settings.input(geofence), // workflow argument
+	)
+	return err
+}
+
+func GetGeofence(ctx context.Context, temporalClient client.Client, name string) (*shared.Geofence, error) {
+	geofenceResp, err := queryGeofence(ctx, temporalClient, name)
+	if err != nil {
+		return nil, err
 	}
+	return geofenceResp.Geofence, nil
+}
 
-	return nil
+func queryGeofence(ctx context.Context, temporalClient client.Client, name string) (*GetGeofenceResponse, error) {
+	resp, err := temporalClient.QueryWorkflow(
+		ctx,                         // context
+		GetGeofenceWorkflowID(name), // workflow id
+		"",                          // run id
+		shared.GeofencesQuery,       // query type
+		&GetGeofenceRequest{},       // query input
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	geofenceResp := &GetGeofenceResponse{}
+	if err := resp.Get(geofenceResp); err != nil {
+		return nil, err
+	}
+	return geofenceResp, nil
+}
+
+// ListGeofences returns the names of the running geofence workflows.
+func ListGeofences(ctx context.Context, temporalClient client.Client) ([]string, error) {
+	return listWorkflows(ctx, temporalClient, "Geofence", GetGeofenceWorkflowID(""))
+}
+
+func UpdateGeofence(ctx context.Context, temporalClient client.Client, geofence *shared.GeofenceDefinition) error {
+	return updateWorkflow(ctx, temporalClient, GetGeofenceWorkflowID(geofence.Name), shared.UpdateGeofenceUpdate, geofence)
+}
+
+// ConfigureGeofence hands the running geofence new settings.
+func ConfigureGeofence(ctx context.Context, temporalClient client.Client, name string, settings GeofenceSettings) error {
+	return updateWorkflow(ctx, temporalClient, GetGeofenceWorkflowID(name), shared.ConfigureGeofenceUpdate, &settings)
+}
+
+// DeleteGeofence completes the geofence workflow, it's rejected while organizations are still assigned.
+func DeleteGeofence(ctx context.Context, temporalClient client.Client, name string) error {
+	return updateWorkflow(ctx, temporalClient, GetGeofenceWorkflowID(name), shared.DeleteGeofenceUpdate)
+}
+
+// getZoneOccupants lists the vehicles of the zone, longest dwelling first, with their dwell up to now.
+func getZoneOccupants(vehiclesInZone map[string]*ZoneVisit, now time.Time) []*shared.ZoneOccupant {
+	occupants := make([]*shared.ZoneOccupant, 0, len(vehiclesInZone))
+	for vehicleID, visit := range vehiclesInZone {
+		occupants = append(occupants, &shared.ZoneOccupant{
+			VehicleId:   vehicleID,
+			EnteredAt:   visit.EnteredAt,
+			DwellMillis: max(0, now.Sub(visit.enteredAt()).Milliseconds()),
+		})
+	}
+
+	sort.Slice(occupants, func(i, j int) bool {
+		if occupants[i].DwellMillis != occupants[j].DwellMillis {
+			return occupants[i].DwellMillis > occupants[j].DwellMillis
+		}
+		return occupants[i].VehicleId < occupants[j].VehicleId
+	})
+	return occupants
 }
 
-func getMapKeys(m map[string]struct{}) []string {
+func getMapKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
@@ -0,0 +1,172 @@
+package workflow
+
+import (
+	"fmt"
+	"realtimemap-temporal/shared"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestGeofenceCarriesDrainedSignalsOver(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	acceptExternalSignals(env)
+
+	settings := DefaultGeofenceSettings
+	settings.Hysteresis.EntryFixes = 2
+	kamppi := &shared.GeofenceDefinition{Name: "Kamppi", Geometry: shared.NewCircleGeometry(60.1687, 24.9316, 300)}
+	position := func(vehicleID string, timestamp int64) *shared.Position {
+		return &shared.Position{VehicleId: vehicleID, OrgId: "0012", Timestamp: timestamp, Latitude: 60.1688, Longitude: 24.9318}
+	}
+	signalDuringContinueAsNew(env,
+		testSignal{shared.GeofenceSignal, position("0012.1234", 1000)},
+		testSignal{shared.GeofenceSignal, position("0012.5678", 1000)},
+		testSignal{shared.GeofenceSignal, position("0012.1234", 2000)},
+		testSignal{shared.GeofenceSignal, position("0012.1234", 3000)},
+	)
+
+	env.ExecuteWorkflow(Geofence, settings.input(kamppi))
+
+	next := &GeofenceInput{}
+	continuedInput(t, env, next)
+	if next.Version != GeofenceStateVersion || next.State == nil {
+		t.Fatalf("next run got no state: %+v", next)
+	}
+	if next.Hysteresis != settings.Hysteresis {
+		t.Errorf("next run got hysteresis %+v, want %+v", next.Hysteresis, settings.Hysteresis)
+	}
+
+	visit, ok := next.State.VehiclesInZone["0012.1234"]
+	if !ok || len(next.State.VehiclesInZone) != 1 {
+		t.Fatalf("next run got vehicles %v in the zone, want 0012.1234", getMapKeys(next.State.VehiclesInZone))
+	}
+	if visit.EnteredAt != 2000 || visit.LastSeenAt != 3000 {
+		t.Errorf("next run got visit entered at %v and last seen at %v, want 2000 and 3000", visit.EnteredAt, visit.LastSeenAt)
+	}
+	if fixes := next.State.Approaching["0012.5678"]; fixes != 1 {
+		t.Errorf("next run got %v entry fixes of 0012.5678, want 1", fixes)
+	}
+}
+
+// Legacy occupancy also holds vehicles seen outside the zone, restoring it must neither time
+// them out nor have them dwell.
+func TestGeofenceDiscardsLegacyOccupancy(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+
+	notifications := 0
+	env.OnSignalExternalWorkflow(mock.Anything, GetNotificationWorkflowID(), mock.Anything, mock.Anything, mock.Anything).
+		Return(nil).
+		Run(func(args mock.Arguments) { notifications++ })
+	acceptExternalSignals(env)
+
+	settings := DefaultGeofenceSettings
+	input := settings.input(&shared.GeofenceDefinition{Name: "Kamppi", Geometry: shared.NewCircleGeometry(60.1687, 24.9316, 300)})
+	input.Version = legacyGeofenceStateVersion
+	input.State = &GeofenceState{
+		VehiclesInZone: map[string]*ZoneVisit{
+			"0012.1234": {EnteredAt: 1000},
+			"0012.5678": {EnteredAt: 2000},
+		},
+		Organizations: map[string]struct{}{"0012": {}},
+	}
+
+	// well past the dwell threshold and the TTL of the restored vehicles
+	env.RegisterDelayedCallback(func() {
+		env.SetContinueAsNewSuggested(true)
+		env.SignalWorkflow(shared.GeofenceSignal, &shared.Position{VehicleId: "0040.431", OrgId: "0040", Timestamp: 3000, Latitude: 60.2, Longitude: 24.9})
+	}, 2*settings.VehicleTTL)
+
+	env.ExecuteWorkflow(Geofence, input)
+
+	if notifications != 0 {
+		t.Errorf("restoring legacy state sent %v notifications", notifications)
+	}
+
+	next := &GeofenceInput{}
+	continuedInput(t, env, next)
+	if len(next.State.VehiclesInZone) != 0 {
+		t.Errorf("next run got vehicles %v in the zone, want none", getMapKeys(next.State.VehiclesInZone))
+	}
+	if _, ok := next.State.Organizations["0012"]; !ok || len(next.State.Organizations) != 1 {
+		t.Errorf("next run got organizations %v, want 0012", sortedKeys(next.State.Organizations))
+	}
+}
+
+// updateOutcome records how an update sent through the test environment ended.
+type updateOutcome struct {
+	err error
+}
+
+func (u *updateOutcome) Accept() {}
+
+func (u *updateOutcome) Reject(err error) {
+	u.err = err
+}
+
+func (u *updateOutcome) Complete(success interface{}, err error) {
+	u.err = err
+}
+
+func TestGeofenceConfigureRestartsTimers(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+
+	startedAt := env.Now()
+	var events []string
+	env.OnSignalExternalWorkflow(mock.Anything, GetNotificationWorkflowID(), mock.Anything, mock.Anything, mock.Anything).
+		Return(nil).
+		Run(func(args mock.Arguments) {
+			notification := args.Get(4).(*shared.Notification)
+			events = append(events, fmt.Sprintf("%v %v%v", env.Now().Sub(startedAt), notification.Event, notification.Reason))
+		})
+	acceptExternalSignals(env)
+
+	settings := DefaultGeofenceSettings
+	configured := settings
+	configured.DwellThreshold, configured.VehicleTTL = 0, 2*time.Minute
+
+	// the vehicle reports every minute past the dwell threshold it entered with
+	for minute := 0; minute <= 6; minute++ {
+		timestamp := int64(minute)*60_000 + 1000
+		env.RegisterDelayedCallback(func() {
+			env.SignalWorkflow(shared.GeofenceSignal, &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: timestamp, Latitude: 60.1688, Longitude: 24.9318})
+		}, time.Duration(timestamp)*time.Millisecond)
+	}
+	configure := &updateOutcome{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(shared.ConfigureGeofenceUpdate, "configure", configure, &configured)
+	}, 2*time.Second)
+	missing := &updateOutcome{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(shared.ConfigureGeofenceUpdate, "missing", missing, nil)
+	}, 3*time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.SetContinueAsNewSuggested(true)
+		env.SignalWorkflow(shared.GeofenceSignal, &shared.Position{VehicleId: "0040.431", OrgId: "0040", Timestamp: 2000, Latitude: 60.2, Longitude: 24.9})
+	}, 30*time.Minute)
+
+	env.ExecuteWorkflow(Geofence, settings.input(&shared.GeofenceDefinition{Name: "Kamppi", Geometry: shared.NewCircleGeometry(60.1687, 24.9316, 300)}))
+
+	if configure.err != nil {
+		t.Fatalf("configuring failed: %v", configure.err)
+	}
+	if !IsValidationError(missing.err) {
+		t.Errorf("configuring without settings got %v, want a validation error", missing.err)
+	}
+
+	// the vehicle times out two minutes after its last position, without dwelling first
+	want := []string{"1s ENTER", "8m1s EXITTIMEOUT"}
+	if fmt.Sprint(events) != fmt.Sprint(want) {
+		t.Errorf("got notifications %v, want %v", events, want)
+	}
+
+	next := &GeofenceInput{}
+	continuedInput(t, env, next)
+	if next.DwellThreshold != configured.DwellThreshold || next.VehicleTTL != configured.VehicleTTL {
+		t.Errorf("next run got dwell threshold %v and TTL %v, want %v and %v", next.DwellThreshold, next.VehicleTTL, configured.DwellThreshold, configured.VehicleTTL)
+	}
+}
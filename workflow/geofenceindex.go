@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"math"
+	"realtimemap-temporal/shared"
+	"sort"
+)
+
+const (
+	// geofenceIndexCellDegrees is the side of a grid cell, about a kilometer north to south.
+	geofenceIndexCellDegrees = 0.01
+	// geofenceIndexMaxCells caps the cells a single geofence is bucketed into, bigger ones
+	// are checked against every position.
+	geofenceIndexMaxCells = 4096
+)
+
+type geofenceCell struct {
+	latitude  int
+	longitude int
+}
+
+// geofenceIndex buckets geofence bounding boxes in a fixed grid, so routing a position only
+// looks at the geofences around it.
+type geofenceIndex struct {
+	boxes map[string]shared.BoundingBox
+	cells map[geofenceCell][]string
+	large []string
+}
+
+// newGeofenceIndex indexes the bounding boxes of the geofences grown by bufferMeters, geofences
+// with an invalid geometry are left out.
+func newGeofenceIndex(geofences []*shared.GeofenceDefinition, bufferMeters float64) *geofenceIndex {
+	index := &geofenceIndex{
+		boxes: make(map[string]shared.BoundingBox, len(geofences)),
+		cells: make(map[geofenceCell][]string),
+	}
+
+	for _, geofence := range geofences {
+		shape, err := geofence.Shape()
+		if err != nil {
+			continue
+		}
+		box := shape.BoundingBox().Expand(bufferMeters)
+		index.boxes[geofence.Name] = box
+
+		from, to := cellOf(box.MinLatitude, box.MinLongitude), cellOf(box.MaxLatitude, box.MaxLongitude)
+		if (to.latitude-from.latitude+1)*(to.longitude-from.longitude+1) > geofenceIndexMaxCells {
+			index.large = append(index.large, geofence.Name)
+			continue
+		}
+		for latitude := from.latitude; latitude <= to.latitude; latitude++ {
+			for longitude := from.longitude; longitude <= to.longitude; longitude++ {
+				cell := geofenceCell{latitude, longitude}
+				index.cells[cell] = append(index.cells[cell], geofence.Name)
+			}
+		}
+	}
+
+	return index
+}
+
+// Candidates returns the sorted names of the geofences whose grown bounding box contains the position.
+func (index *geofenceIndex) Candidates(latitude float64, longitude float64) []string {
+	candidates := make([]string, 0)
+	for _, names := range [][]string{index.cells[cellOf(latitude, longitude)], index.large} {
+		for _, name := range names {
+			if index.boxes[name].Contains(latitude, longitude) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+func cellOf(latitude float64, longitude float64) geofenceCell {
+	return geofenceCell{
+		latitude:  int(math.Floor(latitude / geofenceIndexCellDegrees)),
+		longitude: int(math.Floor(longitude / geofenceIndexCellDegrees)),
+	}
+}
@@ -30,14 +30,11 @@ func Notification(ctx workflow.Context, input *NotificationInput) (*Notification
 	var a *NotifyActivities
 
 	/*****
-		SELECTOR
+		SIGNALS
 	*****/
-	selector := workflow.NewSelector(ctx)
-
-	selector.AddReceive(workflow.GetSignalChannel(ctx, shared.NotificationSignal), func(c workflow.ReceiveChannel, more bool) {
-		notification := &shared.Notification{}
-		c.Receive(ctx, notification)
+	signals := newSignalLoop(ctx)
 
+	handleSignal(ctx, signals, shared.NotificationSignal, func(notification *shared.Notification) {
 		ao := workflow.ActivityOptions{
 			TaskQueue:           shared.RealtimeMapTaskQueue,
 			StartToCloseTimeout: 10 * time.Second,
@@ -52,19 +49,7 @@ func Notification(ctx workflow.Context, input *NotificationInput) (*Notification
 		}
 	})
 
-	for {
-		selector.Select(ctx)
-		// we'll continue this workflow as new one when reaching history length and size limit
-		if workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
-			// after draining all events
-			if !selector.HasPending() {
-				break
-			}
-		}
-		// if you want to test the logic of continuing workflow as new, please change the condition to
-		// "workflow.GetInfo(ctx).GetCurrentHistoryLength() > 100", it'll create new workflow
-		// when history length is at least 100
-	}
+	signals.run(ctx)
 
 	return nil, workflow.NewContinueAsNewError(ctx, Notification, input)
 }
@@ -0,0 +1,39 @@
+package workflow
+
+import (
+	"realtimemap-temporal/shared"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+)
+
+// The Notification workflow carries nothing over, every notification it received has to be
+// published before it continues as new.
+func TestNotificationPublishesDrainedSignals(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+
+	published := make([]string, 0)
+	env.RegisterActivity(&NotifyActivities{})
+	env.OnActivity("Notify", mock.Anything).Return(func(notification *shared.Notification) error {
+		published = append(published, notification.VehicleId)
+		return nil
+	})
+
+	notification := func(vehicleID string) *shared.Notification {
+		return &shared.Notification{VehicleId: vehicleID, OrgId: "0012", ZoneName: "Kamppi", Event: shared.GeofenceEvent_ENTER}
+	}
+	signalDuringContinueAsNew(env,
+		testSignal{shared.NotificationSignal, notification("0012.1234")},
+		testSignal{shared.NotificationSignal, notification("0012.5678")},
+		testSignal{shared.NotificationSignal, notification("0012.9012")},
+	)
+
+	env.ExecuteWorkflow(Notification, &NotificationInput{})
+
+	continuedInput(t, env, &NotificationInput{})
+	if len(published) != 3 || published[0] != "0012.1234" || published[1] != "0012.5678" || published[2] != "0012.9012" {
+		t.Errorf("published %v, want [0012.1234 0012.5678 0012.9012]", published)
+	}
+}
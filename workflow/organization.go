@@ -2,8 +2,11 @@ package workflow
 
 import (
 	"context"
+	"errors"
 	"realtimemap-temporal/data"
 	"realtimemap-temporal/shared"
+	"sort"
+	"time"
 
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/workflow"
@@ -12,74 +15,459 @@ import (
 type OrganizationInput struct {
 	Id        string
 	Name      string
-	Geofences []*shared.CircularGeofence
+	Geofences []*shared.GeofenceDefinition
+	// Hysteresis is the one the geofences run with, routing keeps up with their exit buffer and
+	// fixes. Organizations started without one use DefaultGeofenceHysteresis.
+	Hysteresis *GeofenceHysteresis
+	// VehicleTTL is the one the geofences run with, vehicles silent for that long are dropped from
+	// Nearby. Organizations started without one use DefaultVehicleTTL, zero keeps them.
+	VehicleTTL *time.Duration
+	// Nearby holds, per vehicle, the geofences it was last routed to by their bounding box and
+	// how many positions in a row it has reported outside of it since. Runs started before
+	// routing by bounding box have none, and route to every geofence while they build it up.
+	Nearby map[string]map[string]int
+	// NearbySeenAt is the timestamp of the last position of each vehicle in Nearby, in milliseconds.
+	NearbySeenAt map[string]int64
 }
 
 type OrganizationOutput struct{}
 
+type GetOrganizationRequest struct{}
+
+type GetOrganizationResponse struct {
+	Id         string
+	Name       string
+	Geofences  []*shared.GeofenceDefinition
+	Hysteresis GeofenceHysteresis
+	VehicleTTL time.Duration
+}
+
 func Organization(ctx workflow.Context, input *OrganizationInput) (*OrganizationOutput, error) {
 	log := workflow.GetLogger(ctx)
 
 	log.Info("Organization workflow started")
-	geofences := input.Geofences
+	// runs started before the index keep signalling every geofence
+	indexedRouting := workflow.GetVersion(ctx, geofenceIndexChange, workflow.DefaultVersion, 1) == 1
+	name, geofences := input.Name, input.Geofences
+	signals := newSignalLoop(ctx)
+	deleted := false
+
+	hysteresis := DefaultGeofenceHysteresis
+	if input.Hysteresis != nil {
+		hysteresis = *input.Hysteresis
+	}
+	vehicleTTL := DefaultVehicleTTL
+	if input.VehicleTTL != nil {
+		vehicleTTL = *input.VehicleTTL
+	}
+	// without Nearby the vehicles inside geofences are unknown, so this run still signals every
+	// geofence to see them leave, and hands the Nearby it builds up to the next run
+	seeding := input.Nearby == nil
+	nearby, nearbySeenAt := input.Nearby, input.NearbySeenAt
+	if nearby == nil {
+		nearby = make(map[string]map[string]int)
+	}
+	if nearbySeenAt == nil {
+		nearbySeenAt = make(map[string]int64)
+	}
+	var sweptAt int64
+	index := newGeofenceIndex(geofences, hysteresis.ExitBufferMeters)
 
 	/*****
-		SELECTOR
+		QUERY
 	*****/
-	selector := workflow.NewSelector(ctx)
-	selector.AddReceive(workflow.GetSignalChannel(ctx, shared.OrganizationSignal), func(c workflow.ReceiveChannel, more bool) {
-		position := &shared.Position{}
-		c.Receive(ctx, position)
+	err := workflow.SetQueryHandler(ctx, shared.OrganizationQuery, func(request *GetOrganizationRequest) (*GetOrganizationResponse, error) {
+		return &GetOrganizationResponse{
+			Id:         input.Id,
+			Name:       name,
+			Geofences:  geofences,
+			Hysteresis: hysteresis,
+			VehicleTTL: vehicleTTL,
+		}, nil
+	})
+	if err != nil {
+		log.Error("SetQueryHandler failed", "error", err)
+		return nil, err
+	}
 
+	/*****
+		UPDATES
+	*****/
+	// assigning a geofence that's already assigned replaces its definition
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.AssignGeofenceUpdate, func(ctx workflow.Context, definition *shared.GeofenceDefinition) error {
+		assigned := make([]*shared.GeofenceDefinition, 0, len(geofences)+1)
 		for _, geofence := range geofences {
+			if geofence.Name != definition.Name {
+				assigned = append(assigned, geofence)
+			}
+		}
+		geofences = append(assigned, definition)
+		index = newGeofenceIndex(geofences, hysteresis.ExitBufferMeters)
+		log.Info("Geofence assigned", "geofence", definition.Name)
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, definition *shared.GeofenceDefinition) error {
+			if definition == nil {
+				return validationError("geofence is missing")
+			}
+			if _, err := definition.Shape(); err != nil {
+				return validationError("%v", err)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.UnassignGeofenceUpdate, func(ctx workflow.Context, name string) error {
+		assigned := make([]*shared.GeofenceDefinition, 0, len(geofences))
+		for _, geofence := range geofences {
+			if geofence.Name != name {
+				assigned = append(assigned, geofence)
+			}
+		}
+		geofences = assigned
+		index = newGeofenceIndex(geofences, hysteresis.ExitBufferMeters)
+		for vehicleID, vehicleNearby := range nearby {
+			delete(vehicleNearby, name)
+			if len(vehicleNearby) == 0 {
+				delete(nearby, vehicleID)
+				delete(nearbySeenAt, vehicleID)
+			}
+		}
+		log.Info("Geofence unassigned", "geofence", name)
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, name string) error {
+			for _, geofence := range geofences {
+				if geofence.Name == name {
+					return nil
+				}
+			}
+			return validationError("geofence %v is not assigned to organization %v", name, input.Id)
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.RenameOrganizationUpdate, func(ctx workflow.Context, newName string) error {
+		name = newName
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, newName string) error {
+			if newName == "" {
+				return validationError("organization name can't be empty")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	// routing follows the settings the geofences run with, so it doesn't stop before a geofence
+	// has seen the vehicle leave. Reconciling hands the geofences the same settings in the same pass.
+	err = workflow.SetUpdateHandlerWithOptions(ctx, shared.ConfigureRoutingUpdate, func(ctx workflow.Context, settings *GeofenceSettings) error {
+		hysteresis, vehicleTTL = settings.Hysteresis, settings.VehicleTTL
+		index = newGeofenceIndex(geofences, hysteresis.ExitBufferMeters)
+		log.Info("Routing configured", "exitBufferMeters", hysteresis.ExitBufferMeters, "exitFixes", hysteresis.ExitFixes, "vehicleTTL", vehicleTTL)
+		return nil
+	}, workflow.UpdateHandlerOptions{
+		Validator: func(ctx workflow.Context, settings *GeofenceSettings) error {
+			if settings == nil {
+				return validationError("routing settings are missing")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandler(ctx, shared.DeleteOrganizationUpdate, func(ctx workflow.Context) error {
+		deleted = true
+		signals.stop()
+		return nil
+	})
+	if err != nil {
+		log.Error("SetUpdateHandler failed", "error", err)
+		return nil, err
+	}
+
+	/*****
+		SIGNALS
+	*****/
+	// geofences send their new definition to every organization they're assigned to
+	handleSignal(ctx, signals, shared.GeofenceChangedSignal, func(definition *shared.GeofenceDefinition) {
+		for i, geofence := range geofences {
+			if geofence.Name == definition.Name {
+				geofences[i] = definition
+				index = newGeofenceIndex(geofences, hysteresis.ExitBufferMeters)
+				log.Info("Geofence changed", "geofence", definition.Name)
+				return
+			}
+		}
+	})
+
+	handleSignal(ctx, signals, shared.OrganizationSignal, func(position *shared.Position) {
+		targets := make([]string, 0, len(geofences))
+		if indexedRouting {
+			if vehicleTTL > 0 && position.Timestamp-sweptAt >= vehicleTTL.Milliseconds() {
+				sweepNearby(nearby, nearbySeenAt, position.Timestamp-vehicleTTL.Milliseconds())
+				sweptAt = position.Timestamp
+			}
+			targets = routeToGeofences(index, nearby, position, hysteresis.ExitFixes)
+			if _, ok := nearby[position.VehicleId]; ok {
+				nearbySeenAt[position.VehicleId] = max(nearbySeenAt[position.VehicleId], position.Timestamp)
+			} else {
+				delete(nearbySeenAt, position.VehicleId)
+			}
+		}
+		if !indexedRouting || seeding {
+			targets = make([]string, 0, len(geofences))
+			for _, geofence := range geofences {
+				targets = append(targets, geofence.Name)
+			}
+		}
+
+		for _, name := range targets {
 			workflow.SignalExternalWorkflow(
-				ctx,                                  // context
-				GetGeofenceWorkflowID(geofence.Name), // workflow id
-				"",                                   // run id
-				shared.GeofenceSignal,                // signal name
-				position,                             // signal argument
+				ctx,                         // context
+				GetGeofenceWorkflowID(name), // workflow id
+				"",                          // run id
+				shared.GeofenceSignal,       // signal name
+				position,                    // signal argument
 			)
 		}
 	})
 
-	for {
-		selector.Select(ctx)
-		// we'll continue this workflow as new one when reaching history length and size limit
-		if workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
-			// after draining all events
-			if !selector.HasPending() {
-				break
-			}
+	signals.run(ctx)
+
+	if deleted {
+		log.Info("Organization deleted")
+		return &OrganizationOutput{}, nil
+	}
+
+	// runs without the index carry no Nearby, their successor builds it up
+	continued := &OrganizationInput{
+		Id:         input.Id,
+		Name:       name,
+		Geofences:  geofences,
+		Hysteresis: &hysteresis,
+		VehicleTTL: &vehicleTTL,
+	}
+	if indexedRouting {
+		continued.Nearby, continued.NearbySeenAt = nearby, nearbySeenAt
+	}
+	return nil, workflow.NewContinueAsNewError(ctx, Organization, continued)
+}
+
+// sweepNearby forgets the vehicles whose last position is older than before, their geofences
+// evict them on their own TTL.
+func sweepNearby(nearby map[string]map[string]int, nearbySeenAt map[string]int64, before int64) {
+	for vehicleID := range nearby {
+		if nearbySeenAt[vehicleID] < before {
+			delete(nearby, vehicleID)
+			delete(nearbySeenAt, vehicleID)
+		}
+	}
+}
+
+// routeToGeofences returns the sorted names of the geofences the position goes to: the ones
+// whose bounding box, grown by the exit buffer, contains it, and the ones the vehicle was near
+// until it has been outside their box for exitFixes positions in a row. Outside the grown box
+// a position always counts towards an EXIT, so a vehicle inside a geofence keeps being routed
+// to it until the geofence has seen it leave.
+func routeToGeofences(index *geofenceIndex, nearby map[string]map[string]int, position *shared.Position, exitFixes int) []string {
+	candidates := index.Candidates(position.Latitude, position.Longitude)
+	vehicleNearby, ok := nearby[position.VehicleId]
+	if !ok {
+		if len(candidates) == 0 {
+			return candidates
+		}
+		vehicleNearby = make(map[string]int, len(candidates))
+		nearby[position.VehicleId] = vehicleNearby
+	}
+
+	inBox := toSet(candidates)
+	targets := candidates
+	for name, outside := range vehicleNearby {
+		if _, ok := inBox[name]; ok {
+			continue
+		}
+		targets = append(targets, name)
+
+		if outside+1 >= max(1, exitFixes) {
+			delete(vehicleNearby, name)
+		} else {
+			vehicleNearby[name] = outside + 1
 		}
-		// if you want to test the logic of continuing workflow as new, please change the condition to
-		// "workflow.GetInfo(ctx).GetCurrentHistoryLength() > 100", it'll create new workflow
-		// when history length is at least 100
+	}
+	for _, name := range candidates {
+		vehicleNearby[name] = 0
+	}
+	if len(vehicleNearby) == 0 {
+		delete(nearby, position.VehicleId)
 	}
 
-	return nil, workflow.NewContinueAsNewError(ctx, Organization, input)
+	// signals must be sent in the same order on replay
+	sort.Strings(targets)
+	return targets
 }
 
-func InitOrganization(ctx context.Context, temporalClient client.Client) error {
-	startWorkflowOpts := client.StartWorkflowOptions{
-		TaskQueue: shared.RealtimeMapTaskQueue,
-	}
-
-	for _, org := range data.AllOrganizations {
-		startWorkflowOpts.ID = GetOrganizationWorkflowID(org.Id)
-		_, err := temporalClient.ExecuteWorkflow(
-			ctx,               // context
-			startWorkflowOpts, // start workflow options
-			Organization,      // workflow
-			&OrganizationInput{
-				Id:        org.Id,
-				Name:      org.Name,
-				Geofences: org.Geofences,
-			}, // workflow argument
-		)
+// CreateOrganization starts the workflow of a new organization, it fails when the organization
+// already exists. The organization is registered with its geofences first and starts with their
+// current definitions.
+func CreateOrganization(ctx context.Context, temporalClient client.Client, settings GeofenceSettings, org *data.Organization) error {
+	return createOrganization(ctx, temporalClient, settings, org, "")
+}
+
+// createOrganization is CreateOrganization recording managedBy in the memo, empty records nothing.
+func createOrganization(ctx context.Context, temporalClient client.Client, settings GeofenceSettings, org *data.Organization, managedBy string) error {
+	geofences := make([]*shared.GeofenceDefinition, 0, len(org.Geofences))
+	for _, geofence := range org.Geofences {
+		definition, err := assignOrganization(ctx, temporalClient, geofence.Name, org.Id)
 		if err != nil {
 			return err
 		}
+		geofences = append(geofences, definition)
+	}
+
+	startWorkflowOpts := client.StartWorkflowOptions{
+		ID:                                       GetOrganizationWorkflowID(org.Id),
+		TaskQueue:                                shared.RealtimeMapTaskQueue,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	if managedBy != "" {
+		startWorkflowOpts.Memo = map[string]interface{}{managedByMemo: managedBy}
+	}
+	_, err := temporalClient.ExecuteWorkflow(
+		ctx,               // context
+		startWorkflowOpts, // start workflow options
+		Organization,      // workflow
+		&OrganizationInput{
+			Id:         org.Id,
+			Name:       org.Name,
+			Geofences:  geofences,
+			Hysteresis: &settings.Hysteresis,
+			VehicleTTL: &settings.VehicleTTL,
+			// a new organization has no vehicles in its geofences yet
+			Nearby: make(map[string]map[string]int),
+		}, // workflow argument
+	)
+	return err
+}
+
+// ListOrganizations returns the ids of the running organization workflows.
+func ListOrganizations(ctx context.Context, temporalClient client.Client) ([]string, error) {
+	return listWorkflows(ctx, temporalClient, "Organization", GetOrganizationWorkflowID(""))
+}
+
+func GetOrganization(ctx context.Context, temporalClient client.Client, orgID string) (*GetOrganizationResponse, error) {
+	resp, err := temporalClient.QueryWorkflow(
+		ctx,                              // context
+		GetOrganizationWorkflowID(orgID), // workflow id
+		"",                               // run id
+		shared.OrganizationQuery,         // query type
+		&GetOrganizationRequest{},        // query input
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	orgResp := &GetOrganizationResponse{}
+	if err := resp.Get(orgResp); err != nil {
+		return nil, err
+	}
+	return orgResp, nil
+}
+
+// AssignGeofence adds the geofence to the organization, or refreshes its definition when it's
+// already assigned. The organization is registered with the geofence first, a geofence refuses
+// to be deleted while it has organizations, so no organization is left routing to a deleted one.
+func AssignGeofence(ctx context.Context, temporalClient client.Client, orgID string, name string) error {
+	definition, err := assignOrganization(ctx, temporalClient, name, orgID)
+	if err != nil {
+		return err
+	}
+
+	err = updateWorkflow(ctx, temporalClient, GetOrganizationWorkflowID(orgID), shared.AssignGeofenceUpdate, definition)
+	if err != nil {
+		// the organization doesn't have the geofence, so it mustn't hold the geofence back
+		if unassignErr := unassignOrganization(ctx, temporalClient, name, orgID); unassignErr != nil {
+			return errors.Join(err, unassignErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// UnassignGeofence removes the geofence from the organization, then the organization from the
+// geofence. The latter also runs when the organization rejects the geofence as not assigned,
+// so a registration left by a failed assignment doesn't keep the geofence from being deleted.
+func UnassignGeofence(ctx context.Context, temporalClient client.Client, orgID string, name string) error {
+	err := updateWorkflow(ctx, temporalClient, GetOrganizationWorkflowID(orgID), shared.UnassignGeofenceUpdate, name)
+	if err != nil && !IsValidationError(err) {
+		return err
+	}
+
+	if unassignErr := unassignOrganization(ctx, temporalClient, name, orgID); unassignErr != nil {
+		return errors.Join(err, unassignErr)
+	}
+	return err
+}
+
+// assignOrganization registers the organization with the geofence and returns the definition
+// of the geofence.
+func assignOrganization(ctx context.Context, temporalClient client.Client, name string, orgID string) (*shared.GeofenceDefinition, error) {
+	definition := &shared.GeofenceDefinition{}
+	err := updateWorkflowWithResult(ctx, temporalClient, GetGeofenceWorkflowID(name), shared.AssignOrganizationUpdate, definition, orgID)
+	if err != nil {
+		return nil, err
 	}
+	return definition, nil
+}
 
+// unassignOrganization removes the organization from the geofence, a deleted geofence has none.
+func unassignOrganization(ctx context.Context, temporalClient client.Client, name string, orgID string) error {
+	err := updateWorkflow(ctx, temporalClient, GetGeofenceWorkflowID(name), shared.UnassignOrganizationUpdate, orgID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
 	return nil
 }
+
+// ConfigureRouting hands the organization the settings its geofences run with.
+func ConfigureRouting(ctx context.Context, temporalClient client.Client, orgID string, settings GeofenceSettings) error {
+	return updateWorkflow(ctx, temporalClient, GetOrganizationWorkflowID(orgID), shared.ConfigureRoutingUpdate, &settings)
+}
+
+func RenameOrganization(ctx context.Context, temporalClient client.Client, orgID string, name string) error {
+	return updateWorkflow(ctx, temporalClient, GetOrganizationWorkflowID(orgID), shared.RenameOrganizationUpdate, name)
+}
+
+// DeleteOrganization completes the organization workflow and removes it from its geofences.
+func DeleteOrganization(ctx context.Context, temporalClient client.Client, orgID string) error {
+	org, err := GetOrganization(ctx, temporalClient, orgID)
+	if err != nil {
+		return err
+	}
+
+	if err := updateWorkflow(ctx, temporalClient, GetOrganizationWorkflowID(orgID), shared.DeleteOrganizationUpdate); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, geofence := range org.Geofences {
+		if err := unassignOrganization(ctx, temporalClient, geofence.Name, orgID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"realtimemap-temporal/shared"
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestOrganizationCarriesDrainedSignalsOver(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	acceptExternalSignals(env)
+
+	kamppi := &shared.GeofenceDefinition{Name: "Kamppi", Geometry: shared.NewCircleGeometry(60.1687, 24.9316, 300)}
+	moved := &shared.GeofenceDefinition{Name: "Kamppi", Geometry: shared.NewCircleGeometry(60.1687, 24.9316, 500)}
+	position := func(vehicleID string, timestamp int64) *shared.Position {
+		return &shared.Position{VehicleId: vehicleID, OrgId: "0012", Timestamp: timestamp, Latitude: 60.1688, Longitude: 24.9318}
+	}
+	signalDuringContinueAsNew(env,
+		testSignal{shared.OrganizationSignal, position("0012.1234", 1000)},
+		testSignal{shared.GeofenceChangedSignal, moved},
+		testSignal{shared.OrganizationSignal, position("0012.5678", 1000)},
+		testSignal{shared.OrganizationSignal, position("0012.1234", 2000)},
+	)
+
+	env.ExecuteWorkflow(Organization, &OrganizationInput{
+		Id:        "0012",
+		Name:      "Helsingin Bussiliikenne Oy",
+		Geofences: []*shared.GeofenceDefinition{kamppi},
+		Nearby:    make(map[string]map[string]int),
+	})
+
+	next := &OrganizationInput{}
+	continuedInput(t, env, next)
+
+	if len(next.Geofences) != 1 {
+		t.Fatalf("next run got %v geofences, want 1", len(next.Geofences))
+	}
+	shape, err := next.Geofences[0].Shape()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if circle, ok := shape.(*shared.Circle); !ok || circle.RadiusInMeters != 500 {
+		t.Errorf("next run didn't get the changed geofence: %+v", shape)
+	}
+
+	for _, vehicleID := range []string{"0012.1234", "0012.5678"} {
+		if _, ok := next.Nearby[vehicleID]["Kamppi"]; !ok {
+			t.Errorf("next run doesn't route %v to Kamppi: %v", vehicleID, next.Nearby)
+		}
+	}
+	if seenAt := next.NearbySeenAt["0012.1234"]; seenAt != 2000 {
+		t.Errorf("next run saw 0012.1234 at %v, want 2000", seenAt)
+	}
+}
+
+func TestOrganizationConfiguresRouting(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+
+	settings := DefaultGeofenceSettings
+	settings.Hysteresis.ExitBufferMeters, settings.VehicleTTL = 50, 20*time.Minute
+
+	missing := &updateOutcome{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(shared.ConfigureRoutingUpdate, "missing", missing, nil)
+	}, time.Second)
+	configure := &updateOutcome{}
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(shared.ConfigureRoutingUpdate, "configure", configure, &settings)
+	}, 2*time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.SetContinueAsNewSuggested(true)
+		env.SignalWorkflow(shared.OrganizationSignal, &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Timestamp: 1000, Latitude: 60.2, Longitude: 24.9})
+	}, 3*time.Second)
+
+	env.ExecuteWorkflow(Organization, &OrganizationInput{
+		Id:     "0012",
+		Name:   "Helsingin Bussiliikenne Oy",
+		Nearby: make(map[string]map[string]int),
+	})
+
+	if !IsValidationError(missing.err) {
+		t.Errorf("configuring without settings got %v, want a validation error", missing.err)
+	}
+	if configure.err != nil {
+		t.Fatalf("configuring failed: %v", configure.err)
+	}
+
+	next := &OrganizationInput{}
+	continuedInput(t, env, next)
+	if *next.Hysteresis != settings.Hysteresis || *next.VehicleTTL != settings.VehicleTTL {
+		t.Errorf("next run got hysteresis %+v and TTL %v, want %+v and %v", *next.Hysteresis, *next.VehicleTTL, settings.Hysteresis, settings.VehicleTTL)
+	}
+}
@@ -0,0 +1,197 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"realtimemap-temporal/data"
+	"realtimemap-temporal/shared"
+	"sort"
+
+	"log/slog"
+
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+)
+
+// Reconcile brings the running organization and geofence workflows in line with config and
+// settings: it starts the missing ones and updates the changed ones. With prune it also deletes the workflows
+// it started that are no longer configured and unassigns the geofences it started from the
+// organizations no longer configured with them, workflows and assignments made through the API
+// are left alone. It carries on past failures and returns all of them.
+func Reconcile(ctx context.Context, temporalClient client.Client, config *data.Config, settings GeofenceSettings, prune bool) error {
+	runningGeofences, err := ListGeofences(ctx, temporalClient)
+	if err != nil {
+		return err
+	}
+	runningOrgs, err := ListOrganizations(ctx, temporalClient)
+	if err != nil {
+		return err
+	}
+	managedGeofences, err := listManagedWorkflows(ctx, temporalClient, "Geofence", GetGeofenceWorkflowID(""))
+	if err != nil {
+		return err
+	}
+	managedOrgs, err := listManagedWorkflows(ctx, temporalClient, "Organization", GetOrganizationWorkflowID(""))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	fail := func(err error, msg string, args ...any) {
+		slog.Error(msg, append(args, "error", err)...)
+		errs = append(errs, err)
+	}
+
+	// geofences first, so organizations only ever route to running ones
+	running := toSet(runningGeofences)
+	for _, name := range sortedKeys(config.Geofences) {
+		geofence := config.Geofences[name]
+
+		if _, ok := running[name]; !ok {
+			if err := createGeofence(ctx, temporalClient, settings, geofence, ManagedByConfig); err != nil && !isAlreadyStarted(err) {
+				fail(err, "Creating geofence failed", "geofence", name)
+				continue
+			}
+			slog.Info("Geofence created", "geofence", name)
+			continue
+		}
+
+		current, err := queryGeofence(ctx, temporalClient, name)
+		if err != nil {
+			fail(err, "Querying geofence failed", "geofence", name)
+			continue
+		}
+		if current.Settings != settings {
+			if err := ConfigureGeofence(ctx, temporalClient, name, settings); err != nil {
+				fail(err, "Configuring geofence failed", "geofence", name)
+				continue
+			}
+			slog.Info("Geofence configured", "geofence", name)
+		}
+		if !current.Geofence.Geometry.Equal(geofence.Geometry) {
+			if err := UpdateGeofence(ctx, temporalClient, geofence); err != nil {
+				fail(err, "Updating geofence failed", "geofence", name)
+				continue
+			}
+			slog.Info("Geofence updated", "geofence", name)
+		}
+	}
+
+	running = toSet(runningOrgs)
+	for _, org := range config.SortedOrganizations() {
+		if _, ok := running[org.Id]; !ok {
+			if err := createOrganization(ctx, temporalClient, settings, org, ManagedByConfig); err != nil && !isAlreadyStarted(err) {
+				fail(err, "Creating organization failed", "organization", org.Id)
+				continue
+			}
+			slog.Info("Organization created", "organization", org.Id)
+			continue
+		}
+
+		if err := reconcileOrganization(ctx, temporalClient, org, settings, prune, toSet(managedGeofences)); err != nil {
+			fail(err, "Updating organization failed", "organization", org.Id)
+		}
+	}
+
+	if !prune {
+		return errors.Join(errs...)
+	}
+
+	for _, orgID := range managedOrgs {
+		if _, ok := config.Organizations[orgID]; ok {
+			continue
+		}
+		if err := DeleteOrganization(ctx, temporalClient, orgID); err != nil {
+			fail(err, "Deleting organization failed", "organization", orgID)
+			continue
+		}
+		slog.Info("Organization deleted", "organization", orgID)
+	}
+
+	for _, name := range managedGeofences {
+		if _, ok := config.Geofences[name]; ok {
+			continue
+		}
+		if err := DeleteGeofence(ctx, temporalClient, name); err != nil {
+			fail(err, "Deleting geofence failed", "geofence", name)
+			continue
+		}
+		slog.Info("Geofence deleted", "geofence", name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// reconcileOrganization renames the organization, hands it the current settings and assigns
+// its configured geofences, registering it with every one of them again even when it already
+// routes to them. With prune it unassigns the managed geofences that aren't configured for it.
+func reconcileOrganization(ctx context.Context, temporalClient client.Client, org *data.Organization, settings GeofenceSettings, prune bool, managedGeofences map[string]struct{}) error {
+	current, err := GetOrganization(ctx, temporalClient, org.Id)
+	if err != nil {
+		return err
+	}
+
+	if current.Hysteresis != settings.Hysteresis || current.VehicleTTL != settings.VehicleTTL {
+		if err := ConfigureRouting(ctx, temporalClient, org.Id, settings); err != nil {
+			return err
+		}
+	}
+
+	if current.Name != org.Name {
+		if err := RenameOrganization(ctx, temporalClient, org.Id, org.Name); err != nil {
+			return err
+		}
+	}
+
+	desired := make(map[string]*shared.GeofenceDefinition, len(org.Geofences))
+	for _, geofence := range org.Geofences {
+		desired[geofence.Name] = geofence
+	}
+	assigned := make(map[string]*shared.GeofenceDefinition, len(current.Geofences))
+	for _, geofence := range current.Geofences {
+		assigned[geofence.Name] = geofence
+	}
+
+	for _, name := range sortedKeys(assigned) {
+		if _, managed := managedGeofences[name]; !prune || !managed {
+			continue
+		}
+		if _, ok := desired[name]; !ok {
+			if err := UnassignGeofence(ctx, temporalClient, org.Id, name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range sortedKeys(desired) {
+		if current, ok := assigned[name]; ok && current.Geometry.Equal(desired[name].Geometry) {
+			// registering is idempotent, it restores a registration the geofence lost
+			if _, err := assignOrganization(ctx, temporalClient, name, org.Id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := AssignGeofence(ctx, temporalClient, org.Id, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isAlreadyStarted(err error) bool {
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	return errors.As(err, &alreadyStarted)
+}
+
+func toSet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := getMapKeys(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,34 @@
+package workflow
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.temporal.io/sdk/worker"
+)
+
+// The histories in testdata were written by runs started before the changes gated by
+// workflow.GetVersion, they must keep replaying with the current code.
+func TestReplayLegacyHistories(t *testing.T) {
+	histories, err := filepath.Glob(filepath.Join("testdata", "*_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(histories) == 0 {
+		t.Fatal("no histories in testdata")
+	}
+
+	for _, history := range histories {
+		t.Run(filepath.Base(history), func(t *testing.T) {
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflow(Vehicle)
+			replayer.RegisterWorkflow(Organization)
+			replayer.RegisterWorkflow(Geofence)
+			replayer.RegisterWorkflow(Notification)
+
+			if err := replayer.ReplayWorkflowHistoryFromJSONFile(nil, history); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+// signalLoop serves the signal channels of an actor workflow until Temporal suggests to
+// continue as new, then drains them so no signal received by the current run is lost.
+type signalLoop struct {
+	selector workflow.Selector
+	drains   []func() bool
+	wake     workflow.Channel
+	stopped  bool
+}
+
+func newSignalLoop(ctx workflow.Context) *signalLoop {
+	loop := &signalLoop{
+		selector: workflow.NewSelector(ctx),
+		wake:     workflow.NewChannel(ctx),
+	}
+	loop.selector.AddReceive(loop.wake, func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+	})
+	return loop
+}
+
+// handleSignal decodes every signal sent as signalName into a new T and passes it to handle,
+// both while the loop runs and while it drains.
+func handleSignal[T any](ctx workflow.Context, loop *signalLoop, signalName string, handle func(value *T)) {
+	channel := workflow.GetSignalChannel(ctx, signalName)
+
+	loop.selector.AddReceive(channel, func(c workflow.ReceiveChannel, more bool) {
+		value := new(T)
+		c.Receive(ctx, value)
+		handle(value)
+	})
+
+	loop.drains = append(loop.drains, func() bool {
+		value := new(T)
+		if !channel.ReceiveAsync(value) {
+			return false
+		}
+		handle(value)
+		return true
+	})
+}
+
+// addFuture handles the future, e.g. a timer, once it's ready. Futures still pending when
+// the loop returns are abandoned, the next run has to recreate them from its state.
+func (l *signalLoop) addFuture(future workflow.Future, handle func(f workflow.Future)) {
+	l.selector.AddFuture(future, handle)
+}
+
+// stop makes run return, so the workflow completes instead of continuing as new. It's meant
+// for update handlers, which run outside of the loop.
+func (l *signalLoop) stop() {
+	l.stopped = true
+	l.wake.SendAsync(true)
+}
+
+// run returns once the workflow should continue as new and every buffered signal was handled.
+func (l *signalLoop) run(ctx workflow.Context) {
+	for {
+		l.selector.Select(ctx)
+		// we'll continue this workflow as new one when reaching history length and size limit
+		if l.stopped || workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
+			break
+		}
+		// if you want to test the logic of continuing workflow as new, please change the condition to
+		// "workflow.GetInfo(ctx).GetCurrentHistoryLength() > 100", it'll create new workflow
+		// when history length is at least 100
+	}
+
+	l.drain()
+}
+
+// drain handles buffered signals until a full pass over the channels finds none. Handlers may
+// block, e.g. on an activity, and more signals can arrive meanwhile, hence the repeated passes.
+func (l *signalLoop) drain() {
+	for drained := true; drained; {
+		drained = false
+		for _, receive := range l.drains {
+			for receive() {
+				drained = true
+			}
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+type testSignal struct {
+	name string
+	arg  interface{}
+}
+
+// signalDuringContinueAsNew buffers the signals and has Temporal suggest to continue as new
+// before the workflow wakes up for the last one, so all but one are only handled when the run
+// drains its channels.
+func signalDuringContinueAsNew(env *testsuite.TestWorkflowEnvironment, signals ...testSignal) {
+	env.RegisterDelayedCallback(func() {
+		last := len(signals) - 1
+		for _, signal := range signals[:last] {
+			env.SignalWorkflowSkippingWorkflowTask(signal.name, signal.arg)
+		}
+		env.SetContinueAsNewSuggested(true)
+		env.SignalWorkflow(signals[last].name, signals[last].arg)
+	}, time.Second)
+}
+
+// continuedInput decodes the input the workflow continued as new with.
+func continuedInput(t *testing.T, env *testsuite.TestWorkflowEnvironment, input interface{}) {
+	t.Helper()
+
+	var continueAsNew *workflow.ContinueAsNewError
+	if err := env.GetWorkflowError(); !errors.As(err, &continueAsNew) {
+		t.Fatalf("workflow didn't continue as new: %v", err)
+	}
+	if err := converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, input); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// acceptExternalSignals lets the workflow signal other workflows, the test environment fails
+// signals to workflows it doesn't run unless they're mocked.
+func acceptExternalSignals(env *testsuite.TestWorkflowEnvironment) {
+	env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+}
@@ -1,6 +1,19 @@
 package workflow
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+)
 
 func GetVehicleWorkflowID(vehicleID string) string {
 	return fmt.Sprintf("vehicle-%v", vehicleID)
@@ -17,3 +30,108 @@ func GetGeofenceWorkflowID(name string) string {
 func GetNotificationWorkflowID() string {
 	return "notification"
 }
+
+// change ids of workflow.GetVersion, runs started before a change keep sending the commands
+// they sent before so their history still replays
+const (
+	doorEventsChange      = "door-events"
+	vehicleOrderingChange = "vehicle-ordering"
+	geofenceVisitsChange  = "geofence-visits"
+	geofenceIndexChange   = "geofence-index"
+)
+
+// ValidationErrorType is the type of the application errors update validators reject with.
+const ValidationErrorType = "ValidationError"
+
+// validationError is returned by update validators, so callers can tell a rejected request
+// from a failure.
+func validationError(format string, args ...any) error {
+	return temporal.NewApplicationError(fmt.Sprintf(format, args...), ValidationErrorType)
+}
+
+// IsValidationError reports whether err is an update rejected by its validator.
+func IsValidationError(err error) bool {
+	var applicationErr *temporal.ApplicationError
+	return errors.As(err, &applicationErr) && applicationErr.Type() == ValidationErrorType
+}
+
+func isNotFound(err error) bool {
+	var notFound *serviceerror.NotFound
+	return errors.As(err, &notFound)
+}
+
+// updateWorkflow runs an update and waits for its result, a rejected update returns the
+// validation error.
+func updateWorkflow(ctx context.Context, temporalClient client.Client, workflowID string, updateName string, args ...interface{}) error {
+	return updateWorkflowWithResult(ctx, temporalClient, workflowID, updateName, nil, args...)
+}
+
+// updateWorkflowWithResult is updateWorkflow for updates returning a value, it's stored in result.
+func updateWorkflowWithResult(ctx context.Context, temporalClient client.Client, workflowID string, updateName string, result interface{}, args ...interface{}) error {
+	handle, err := temporalClient.UpdateWorkflow(ctx, workflowID, "", updateName, args...)
+	if err != nil {
+		return err
+	}
+	return handle.Get(ctx, result)
+}
+
+// managedByMemo is the memo field naming what started a workflow, workflows started by the
+// config carry ManagedByConfig and are the only ones Reconcile deletes. Continue-as-new keeps it.
+const (
+	managedByMemo   = "managedBy"
+	ManagedByConfig = "config"
+)
+
+// listWorkflows returns the ids, without prefix, of the running workflows of the given type.
+func listWorkflows(ctx context.Context, temporalClient client.Client, workflowType string, prefix string) ([]string, error) {
+	return listWorkflowsWhere(ctx, temporalClient, workflowType, prefix, func(*workflowpb.WorkflowExecutionInfo) bool {
+		return true
+	})
+}
+
+// listManagedWorkflows returns the ids, without prefix, of the running workflows of the given
+// type that were started by the config.
+func listManagedWorkflows(ctx context.Context, temporalClient client.Client, workflowType string, prefix string) ([]string, error) {
+	return listWorkflowsWhere(ctx, temporalClient, workflowType, prefix, func(execution *workflowpb.WorkflowExecutionInfo) bool {
+		payload, ok := execution.GetMemo().GetFields()[managedByMemo]
+		if !ok {
+			return false
+		}
+
+		var managedBy string
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, &managedBy); err != nil {
+			return false
+		}
+		return managedBy == ManagedByConfig
+	})
+}
+
+func listWorkflowsWhere(ctx context.Context, temporalClient client.Client, workflowType string, prefix string, keep func(*workflowpb.WorkflowExecutionInfo) bool) ([]string, error) {
+	ids := make([]string, 0)
+
+	var nextPageToken []byte
+	for {
+		resp, err := temporalClient.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         fmt.Sprintf("WorkflowType = '%v' AND ExecutionStatus = 'Running'", workflowType),
+			NextPageToken: nextPageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, execution := range resp.Executions {
+			if !keep(execution) {
+				continue
+			}
+			ids = append(ids, strings.TrimPrefix(execution.Execution.WorkflowId, prefix))
+		}
+
+		nextPageToken = resp.NextPageToken
+		if len(nextPageToken) == 0 {
+			break
+		}
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
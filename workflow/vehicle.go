@@ -8,9 +8,77 @@ import (
 	"go.temporal.io/sdk/workflow"
 )
 
-const MaxPositionHistory = 200
+const (
+	MaxPositionHistory = 200
+	MaxEventHistory    = 50
+)
+
+// notifiedVehicleEvents are forwarded to the Notification workflow in addition to being kept in the trail.
+var notifiedVehicleEvents = map[string]struct{}{
+	shared.VehicleEvent_ARR: {},
+	shared.VehicleEvent_DEP: {},
+	shared.VehicleEvent_DOO: {},
+	shared.VehicleEvent_DOC: {},
+}
+
+// VehicleStateVersion is bumped whenever VehicleState changes in a way older workflows can't read.
+const VehicleStateVersion = 1
+
+type VehicleInput struct {
+	// Ordering decides which late or repeated positions are dropped.
+	Ordering shared.OrderingPolicy
+	// Version of State, inputs written before state was carried over have neither.
+	Version int
+	// State is handed over from the previous run on continue-as-new.
+	State *VehicleState
+}
+
+type VehicleState struct {
+	PositionHistory []*shared.Position
+	EventHistory    []*shared.VehicleEvent
+	DoorsOpen       bool
+	DoorStateKnown  bool
+	Ordering        *shared.OrderingFilter
+	Counters        VehicleCounters
+}
+
+type VehicleCounters struct {
+	PositionsAccepted int64
+	PositionsDropped  int64
+	Events            int64
+}
+
+// restoreVehicleState returns the state carried by input, or a fresh one when there's none or
+// it was written by a version this code doesn't know.
+func restoreVehicleState(ctx workflow.Context, input *VehicleInput) *VehicleState {
+	state := &VehicleState{
+		PositionHistory: make([]*shared.Position, 0),
+		EventHistory:    make([]*shared.VehicleEvent, 0),
+		Ordering:        shared.NewOrderingFilter(input.Ordering),
+	}
 
-type VehicleInput struct{}
+	switch {
+	case input.State == nil:
+		return state
+	case input.Version != VehicleStateVersion:
+		workflow.GetLogger(ctx).Warn("Discarding vehicle state of unknown version", "version", input.Version)
+		return state
+	}
+
+	restored := input.State
+	if restored.PositionHistory == nil {
+		restored.PositionHistory = state.PositionHistory
+	}
+	if restored.EventHistory == nil {
+		restored.EventHistory = state.EventHistory
+	}
+	if restored.Ordering == nil {
+		restored.Ordering = state.Ordering
+	}
+	// the policy always comes from the input, the filter only carries what it has seen
+	restored.Ordering.Policy = input.Ordering
+	return restored
+}
 
 type VehicleOutput struct{}
 
@@ -24,14 +92,27 @@ func Vehicle(ctx workflow.Context, input *VehicleInput) (*VehicleOutput, error)
 	log := workflow.GetLogger(ctx)
 
 	log.Info("Vehicle workflow started")
-	positionHistory := make([]*shared.Position, 0)
+	// runs started before door transitions were notified would send signals their history lacks
+	doorEvents := workflow.GetVersion(ctx, doorEventsChange, workflow.DefaultVersion, 1) == 1
+	// and runs started before positions were filtered would skip signals their history has
+	filtersPositions := workflow.GetVersion(ctx, vehicleOrderingChange, workflow.DefaultVersion, 1) == 1
+	state := restoreVehicleState(ctx, input)
+	positionHistory := state.PositionHistory
+	eventHistory := state.EventHistory
+	doorsOpen, doorStateKnown := state.DoorsOpen, state.DoorStateKnown
+	ordering := state.Ordering
+	counters := state.Counters
 
 	/*****
 		QUERY
 	*****/
 	err := workflow.SetQueryHandler(ctx, shared.VehiclePositionHistoryQuery, func(request *GetPositionHistoryRequest) (*GetPositionHistoryResponse, error) {
 		return &GetPositionHistoryResponse{
-			Positions: &shared.PositionBatch{Positions: positionHistory},
+			Positions: &shared.PositionBatch{
+				Positions: positionHistory,
+				Events:    eventHistory,
+				DoorsOpen: doorsOpen,
+			},
 		}, nil
 	})
 	if err != nil {
@@ -39,19 +120,63 @@ func Vehicle(ctx workflow.Context, input *VehicleInput) (*VehicleOutput, error)
 		return nil, err
 	}
 
+	recordEvent := func(event *shared.VehicleEvent) {
+		if len(eventHistory) > MaxEventHistory {
+			eventHistory = eventHistory[1:]
+		}
+		eventHistory = append(eventHistory, event)
+		counters.Events++
+
+		if _, ok := notifiedVehicleEvents[event.Type]; ok {
+			workflow.SignalExternalWorkflow(
+				ctx,
+				GetNotificationWorkflowID(),
+				"",
+				shared.NotificationSignal,
+				&shared.Notification{
+					VehicleId:     event.VehicleId,
+					OrgId:         event.OrgId,
+					OrgName:       event.OrgName,
+					TransportMode: event.TransportMode,
+					RouteId:       event.RouteId,
+					DirectionId:   event.DirectionId,
+					StopId:        event.StopId,
+					Event:         event.Type,
+				},
+			)
+		}
+	}
+
 	/*****
-		SELECTOR
+		SIGNALS
 	*****/
-	selector := workflow.NewSelector(ctx)
+	signals := newSignalLoop(ctx)
 
-	selector.AddReceive(workflow.GetSignalChannel(ctx, shared.VehicleSignal), func(c workflow.ReceiveChannel, more bool) {
-		position := &shared.Position{}
-		c.Receive(ctx, position)
+	handleSignal(ctx, signals, shared.VehicleSignal, func(position *shared.Position) {
+		if accepted, reason := ordering.Accept(position.Event, position.Timestamp); filtersPositions && !accepted {
+			counters.PositionsDropped++
+			workflow.GetMetricsHandler(ctx).
+				WithTags(map[string]string{"reason": reason}).
+				Counter("vehicle_position_dropped").
+				Inc(1)
+			return
+		}
 
 		if len(positionHistory) > MaxPositionHistory {
 			positionHistory = positionHistory[1:]
 		}
 		positionHistory = append(positionHistory, position)
+		counters.PositionsAccepted++
+
+		// the first position only tells us the current state, every later change is a transition
+		if doorEvents && doorStateKnown && position.DoorsOpen != doorsOpen {
+			eventType := shared.VehicleEvent_DOC
+			if position.DoorsOpen {
+				eventType = shared.VehicleEvent_DOO
+			}
+			recordEvent(doorEvent(eventType, position))
+		}
+		doorsOpen, doorStateKnown = position.DoorsOpen, true
 
 		workflow.SignalExternalWorkflow(
 			ctx, // context
@@ -62,24 +187,47 @@ func Vehicle(ctx workflow.Context, input *VehicleInput) (*VehicleOutput, error)
 		)
 	})
 
-	for {
-		selector.Select(ctx)
-		// we'll continue this workflow as new one when reaching history length and size limit
-		if workflow.GetInfo(ctx).GetContinueAsNewSuggested() {
-			// after draining all events
-			if !selector.HasPending() {
-				break
-			}
-		}
-		// if you want to test the logic of continuing workflow as new, please change the condition to
-		// "workflow.GetInfo(ctx).GetCurrentHistoryLength() > 100", it'll create new workflow
-		// when history length is at least 100
+	for _, eventType := range shared.VehicleEventTypes {
+		handleSignal(ctx, signals, shared.VehicleEventSignal(eventType), func(event *shared.VehicleEvent) {
+			recordEvent(event)
+		})
 	}
 
-	return nil, workflow.NewContinueAsNewError(ctx, Vehicle, input)
+	signals.run(ctx)
+
+	log.Info("Continuing vehicle workflow as new", "positions", len(positionHistory), "events", len(eventHistory))
+	return nil, workflow.NewContinueAsNewError(ctx, Vehicle, &VehicleInput{
+		Ordering: input.Ordering,
+		Version:  VehicleStateVersion,
+		State: &VehicleState{
+			PositionHistory: positionHistory,
+			EventHistory:    eventHistory,
+			DoorsOpen:       doorsOpen,
+			DoorStateKnown:  doorStateKnown,
+			Ordering:        ordering,
+			Counters:        counters,
+		},
+	})
+}
+
+func doorEvent(eventType string, position *shared.Position) *shared.VehicleEvent {
+	return &shared.VehicleEvent{
+		Type:          eventType,
+		VehicleId:     position.VehicleId,
+		OrgId:         position.OrgId,
+		OrgName:       position.OrgName,
+		TransportMode: position.TransportMode,
+		RouteId:       position.RouteId,
+		DirectionId:   position.DirectionId,
+		Line:          position.Line,
+		StopId:        position.StopId,
+		Timestamp:     position.Timestamp,
+		Longitude:     position.Longitude,
+		Latitude:      position.Latitude,
+	}
 }
 
-func InitVehicle(ctx context.Context, temporalClient client.Client, position *shared.Position) error {
+func InitVehicle(ctx context.Context, temporalClient client.Client, input *VehicleInput, position *shared.Position) error {
 	workflowID := GetVehicleWorkflowID(position.VehicleId)
 	startWorkflowOpts := client.StartWorkflowOptions{
 		TaskQueue: shared.RealtimeMapTaskQueue,
@@ -92,7 +240,29 @@ func InitVehicle(ctx context.Context, temporalClient client.Client, position *sh
 		position,             // signal argument
 		startWorkflowOpts,    // start workflow options
 		Vehicle,              // workflow
-		&VehicleInput{},      // workflow arguments
+		input,                // workflow arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func InitVehicleEvent(ctx context.Context, temporalClient client.Client, input *VehicleInput, event *shared.VehicleEvent) error {
+	workflowID := GetVehicleWorkflowID(event.VehicleId)
+	startWorkflowOpts := client.StartWorkflowOptions{
+		TaskQueue: shared.RealtimeMapTaskQueue,
+	}
+
+	_, err := temporalClient.SignalWithStartWorkflow(
+		ctx,                                   // context
+		workflowID,                            // workflow id
+		shared.VehicleEventSignal(event.Type), // signal name
+		event,                                 // signal argument
+		startWorkflowOpts,                     // start workflow options
+		Vehicle,                               // workflow
+		input,                                 // workflow arguments
 	)
 	if err != nil {
 		return err
@@ -0,0 +1,58 @@
+package workflow
+
+import (
+	"realtimemap-temporal/shared"
+	"testing"
+
+	"go.temporal.io/sdk/testsuite"
+)
+
+func TestVehicleCarriesDrainedSignalsOver(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	acceptExternalSignals(env)
+
+	position := func(timestamp int64, doorsOpen bool) *shared.Position {
+		return &shared.Position{VehicleId: "0012.1234", OrgId: "0012", Event: shared.VehicleEvent_VP, Timestamp: timestamp, DoorsOpen: doorsOpen}
+	}
+	signalDuringContinueAsNew(env,
+		testSignal{shared.VehicleSignal, position(1000, false)},
+		testSignal{shared.VehicleSignal, position(2000, true)},
+		testSignal{shared.VehicleEventSignal(shared.VehicleEvent_ARR), &shared.VehicleEvent{Type: shared.VehicleEvent_ARR, VehicleId: "0012.1234", OrgId: "0012", Timestamp: 2500}},
+		testSignal{shared.VehicleSignal, position(3000, true)},
+	)
+
+	env.ExecuteWorkflow(Vehicle, &VehicleInput{Ordering: shared.DefaultOrderingPolicy})
+
+	next := &VehicleInput{}
+	continuedInput(t, env, next)
+	if next.Version != VehicleStateVersion || next.State == nil {
+		t.Fatalf("next run got no state: %+v", next)
+	}
+
+	state := next.State
+	timestamps := make([]int64, 0, len(state.PositionHistory))
+	for _, position := range state.PositionHistory {
+		timestamps = append(timestamps, position.Timestamp)
+	}
+	if len(timestamps) != 3 || timestamps[0] != 1000 || timestamps[1] != 2000 || timestamps[2] != 3000 {
+		t.Errorf("next run got positions %v, want [1000 2000 3000]", timestamps)
+	}
+
+	events := make(map[string]bool)
+	for _, event := range state.EventHistory {
+		events[event.Type] = true
+	}
+	if len(state.EventHistory) != 2 || !events[shared.VehicleEvent_DOO] || !events[shared.VehicleEvent_ARR] {
+		t.Errorf("next run got %v events, want DOO and ARR", len(state.EventHistory))
+	}
+	if !state.DoorsOpen || !state.DoorStateKnown {
+		t.Errorf("next run doesn't know the doors are open")
+	}
+	if state.Ordering == nil || state.Ordering.Last != 3000 {
+		t.Errorf("next run's ordering filter didn't see the last position: %+v", state.Ordering)
+	}
+	if state.Counters.PositionsAccepted != 3 || state.Counters.Events != 2 {
+		t.Errorf("next run got counters %+v", state.Counters)
+	}
+}